@@ -0,0 +1,741 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/exp/slices"
+)
+
+// dumpSchemaVersion is the version of the backup format. Restoring a dump
+// with a newer version fails instead of silently dropping data; older ones
+// are read with the fields they lack left empty. Version 2 added the
+// duration_ms of reviews, version 3 the guid and modified time sync uses.
+const dumpSchemaVersion = 3
+
+// backupCmd represents the backup command
+var backupCmd = &cobra.Command{
+	Use:   "backup <file>",
+	Short: "Write the whole collection to a JSON file",
+	Long: `
+Write every deck, note type, note, card with its scheduling, review and
+setting to a JSON file that can be read back with restore, on this or on
+another machine. The file is gzipped when its name ends in .gz.
+
+backup list and backup restore manage the copies of the database that are
+taken automatically every day and week.
+	`,
+	Example: `  playita backup playita.json.gz`,
+	Args:    cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		db := openDb()
+		dump, err := db.dumpCollection()
+		if err != nil {
+			log.Fatal("Failed to read collection ", err)
+		}
+		if err := writeDump(args[0], dump); err != nil {
+			log.Fatal("Failed to write backup ", err)
+		}
+		fmt.Printf("Backed up %d decks, %d notes and %d cards to %s\n", len(dump.Decks), len(dump.Notes), len(dump.Cards), args[0])
+	},
+}
+
+// restoreCmd represents the restore command
+var restoreCmd = &cobra.Command{
+	Use:   "restore <file>",
+	Short: "Load a collection written by backup",
+	Long: `
+Load a file written by backup. The whole file is checked before anything is
+changed and it is loaded in one transaction, so a failed restore leaves the
+collection as it was.
+
+--replace deletes the current collection and loads the backup as it was,
+keeping the identity sync knows its rows by. Replacing isn't sent to other
+devices as a change: the next sync brings back whatever they changed later
+than the backup and leaves the rest as restored. Rows of backups written
+before playita kept that identity are sent as new rows.
+--merge adds the notes, cards and reviews of the backup to the current
+collection with new ids. Decks are matched by name, note types by name
+when their fields and templates are the same, and settings that are
+already set are kept.
+	`,
+	Example: `  playita restore playita.json.gz --merge
+  playita restore playita.json.gz --replace`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		merge, _ := cmd.Flags().GetBool("merge")
+		replace, _ := cmd.Flags().GetBool("replace")
+		yes, _ := cmd.Flags().GetBool("yes")
+		if merge == replace {
+			log.Fatal("pass either --merge or --replace")
+		}
+
+		dump, err := readDump(args[0])
+		if err != nil {
+			log.Fatal("Failed to read backup ", err)
+		}
+		if err := dump.validate(); err != nil {
+			log.Fatal("Invalid backup ", err)
+		}
+
+		db := openDb()
+		if replace && !yes && !confirm("Replace the whole collection") {
+			fmt.Println("Restore cancelled")
+			return
+		}
+		if err := db.restoreDump(dump, replace); err != nil {
+			log.Fatal("Failed to restore backup ", err)
+		}
+		fmt.Printf("Restored %d decks, %d notes and %d cards from %s\n", len(dump.Decks), len(dump.Notes), len(dump.Cards), args[0])
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(backupCmd)
+	rootCmd.AddCommand(restoreCmd)
+
+	restoreCmd.Flags().Bool("merge", false, "add the backup to the current collection")
+	restoreCmd.Flags().Bool("replace", false, "replace the current collection with the backup")
+	restoreCmd.Flags().BoolP("yes", "y", false, "don't ask for confirmation")
+	restoreCmd.MarkFlagsMutuallyExclusive("merge", "replace")
+}
+
+type collectionDump struct {
+	SchemaVersion int               `json:"schema_version"`
+	CreatedAt     time.Time         `json:"created_at"`
+	Settings      map[string]string `json:"settings"`
+	Decks         []deckDump        `json:"decks"`
+	NoteTypes     []noteTypeDump    `json:"note_types"`
+	Notes         []noteDump        `json:"notes"`
+	Cards         []cardDump        `json:"cards"`
+	Reviews       []reviewDump      `json:"reviews"`
+}
+
+// syncDump is the identity of a row sync knows it by, kept by restore
+// --replace so restoring isn't seen as deleting every row and adding new
+// ones.
+type syncDump struct {
+	Guid     string     `json:"guid,omitempty"`
+	Modified *time.Time `json:"modified,omitempty"`
+}
+
+type deckDump struct {
+	Id int `json:"id"`
+	syncDump
+	Name     string            `json:"name"`
+	Settings map[string]string `json:"settings,omitempty"`
+}
+
+type noteTypeDump struct {
+	Id int `json:"id"`
+	syncDump
+	Name      string           `json:"name"`
+	Fields    []string         `json:"fields"`
+	Templates []templateRecord `json:"templates"`
+}
+
+type noteDump struct {
+	Id int `json:"id"`
+	syncDump
+	DeckId     int               `json:"deck_id"`
+	NoteTypeId int               `json:"note_type_id"`
+	Fields     map[string]string `json:"fields"`
+	Tags       string            `json:"tags"`
+}
+
+type cardDump struct {
+	Id int `json:"id"`
+	syncDump
+	DeckId      int        `json:"deck_id"`
+	NoteId      int        `json:"note_id"`
+	Ord         int        `json:"ord"`
+	Front       string     `json:"front"`
+	Back        string     `json:"back"`
+	Interval    int        `json:"interval"`
+	EaseFactor  float32    `json:"ease_factor"`
+	Repetition  int        `json:"repetition"`
+	ReviewDate  time.Time  `json:"review_date"`
+	BuriedUntil *time.Time `json:"buried_until"`
+	Suspended   bool       `json:"suspended"`
+	Flag        int        `json:"flag"`
+	Lapses      int        `json:"lapses"`
+}
+
+type reviewDump struct {
+	Guid       string    `json:"guid,omitempty"`
+	CardId     int       `json:"card_id"`
+	ReviewedAt time.Time `json:"reviewed_at"`
+	Grade      int       `json:"grade"`
+	Interval   int       `json:"interval"`
+	EaseFactor float32   `json:"ease_factor"`
+	Duration   int64     `json:"duration_ms,omitempty"`
+}
+
+func (nt noteTypeDump) noteType() NoteType {
+	templates := []CardTemplate{}
+	for _, t := range nt.Templates {
+		templates = append(templates, CardTemplate{Name: t.Name, Front: t.Front, Back: t.Back})
+	}
+	return NoteType{Id: nt.Id, Name: nt.Name, Fields: nt.Fields, Templates: templates}
+}
+
+// dumpCollection reads the whole collection in one transaction so the dump
+// is consistent.
+func (db *DB) dumpCollection() (*collectionDump, error) {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	dump := &collectionDump{
+		SchemaVersion: dumpSchemaVersion,
+		CreatedAt:     db.now().UTC(),
+		Settings:      map[string]string{},
+		Decks:         []deckDump{},
+		NoteTypes:     []noteTypeDump{},
+		Notes:         []noteDump{},
+		Cards:         []cardDump{},
+		Reviews:       []reviewDump{},
+	}
+
+	err = scanEach(tx, "SELECT Key, Value FROM Settings ORDER BY Key", func(rows *sql.Rows) error {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			return err
+		}
+		dump.Settings[key] = value
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	identities := map[string]map[int]syncDump{}
+	for _, table := range syncTables[:len(syncTables)-1] {
+		if identities[table], err = syncIdentities(tx, table); err != nil {
+			return nil, err
+		}
+	}
+
+	deckIndex := map[int]int{}
+	err = scanEach(tx, "SELECT Id, Name FROM Decks ORDER BY Id", func(rows *sql.Rows) error {
+		deck := deckDump{}
+		if err := rows.Scan(&deck.Id, &deck.Name); err != nil {
+			return err
+		}
+		deck.syncDump = identities["Decks"][deck.Id]
+		deckIndex[deck.Id] = len(dump.Decks)
+		dump.Decks = append(dump.Decks, deck)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = scanEach(tx, "SELECT DeckId, Key, Value FROM DeckSettings ORDER BY DeckId, Key", func(rows *sql.Rows) error {
+		var deckId int
+		var key, value string
+		if err := rows.Scan(&deckId, &key, &value); err != nil {
+			return err
+		}
+		if i, ok := deckIndex[deckId]; ok {
+			if dump.Decks[i].Settings == nil {
+				dump.Decks[i].Settings = map[string]string{}
+			}
+			dump.Decks[i].Settings[key] = value
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	noteTypes, err := queryNoteTypes(tx)
+	if err != nil {
+		return nil, err
+	}
+	for _, nt := range noteTypes {
+		dump.NoteTypes = append(dump.NoteTypes, noteTypeDump{Id: nt.Id, syncDump: identities["NoteTypes"][nt.Id], Name: nt.Name, Fields: nt.Fields, Templates: newNoteTypeRecord(nt).Templates})
+	}
+
+	// Rows whose deck, note type, note or card is gone, which older versions
+	// left behind when deleting, are left out as restore would reject them.
+	dumpedNotes := "SELECT Id FROM Notes WHERE DeckId IN (SELECT Id FROM Decks) AND NoteTypeId IN (SELECT Id FROM NoteTypes)"
+	dumpedCards := "SELECT Id FROM Cards WHERE DeckId IN (SELECT Id FROM Decks) AND (COALESCE(NoteId, 0) = 0 OR NoteId IN (" + dumpedNotes + "))"
+
+	notes, err := queryNotes(tx, "SELECT Id, DeckId, NoteTypeId, Fields, Tags FROM Notes WHERE Id IN ("+dumpedNotes+") ORDER BY Id")
+	if err != nil {
+		return nil, err
+	}
+	for _, note := range notes {
+		dump.Notes = append(dump.Notes, noteDump{Id: note.Id, syncDump: identities["Notes"][note.Id], DeckId: note.DeckId, NoteTypeId: note.NoteTypeId, Fields: note.Fields, Tags: note.Tags})
+	}
+
+	err = scanEach(tx, "SELECT "+cardColumns+" FROM Cards WHERE Id IN ("+dumpedCards+") ORDER BY Id", func(rows *sql.Rows) error {
+		c, err := scanCard(rows)
+		if err != nil {
+			return err
+		}
+		card := cardDump{
+			Id:         c.Id,
+			syncDump:   identities["Cards"][c.Id],
+			DeckId:     c.DeckId,
+			NoteId:     c.NoteId,
+			Ord:        c.Ord,
+			Front:      c.Front,
+			Back:       c.Back,
+			Interval:   c.Interval,
+			EaseFactor: c.EaseFactor,
+			Repetition: c.Repetition,
+			ReviewDate: c.ReviewDate.UTC(),
+			Suspended:  c.Suspended,
+			Flag:       c.Flag,
+			Lapses:     c.Lapses,
+		}
+		if !c.BuriedUntil.IsZero() {
+			buriedUntil := c.BuriedUntil.UTC()
+			card.BuriedUntil = &buriedUntil
+		}
+		dump.Cards = append(dump.Cards, card)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = scanEach(tx, "SELECT COALESCE(Guid, ''), CardId, ReviewedAt, Grade, Interval, EaseFactor, Duration FROM Reviews WHERE CardId IN ("+dumpedCards+") ORDER BY Id", func(rows *sql.Rows) error {
+		review := reviewDump{}
+		if err := rows.Scan(&review.Guid, &review.CardId, &review.ReviewedAt, &review.Grade, &review.Interval, &review.EaseFactor, &review.Duration); err != nil {
+			return err
+		}
+		review.ReviewedAt = review.ReviewedAt.UTC()
+		dump.Reviews = append(dump.Reviews, review)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return dump, nil
+}
+
+// syncIdentities reads the Guid and Modified time of every row of table by
+// id.
+func syncIdentities(q querier, table string) (map[int]syncDump, error) {
+	identities := map[int]syncDump{}
+	err := scanEach(q, "SELECT Id, COALESCE(Guid, ''), Modified FROM "+table, func(rows *sql.Rows) error {
+		var id int
+		var modified sql.NullTime
+		identity := syncDump{}
+		if err := rows.Scan(&id, &identity.Guid, &modified); err != nil {
+			return err
+		}
+		if modified.Valid {
+			t := modified.Time.UTC()
+			identity.Modified = &t
+		}
+		identities[id] = identity
+		return nil
+	})
+	return identities, err
+}
+
+// scanEach runs stmt and calls scan for every row.
+func scanEach(q querier, stmt string, scan func(rows *sql.Rows) error) error {
+	rows, err := q.Query(stmt)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := scan(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// writeDump writes dump next to path and renames it into place, so an
+// interrupted backup never leaves a truncated file behind.
+func writeDump(path string, dump *collectionDump) error {
+	f, err := os.CreateTemp(filepath.Dir(path), ".playita-backup-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	var w io.Writer = f
+	var zw *gzip.Writer
+	if strings.HasSuffix(path, ".gz") {
+		zw = gzip.NewWriter(f)
+		w = zw
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(dump); err != nil {
+		return err
+	}
+	if zw != nil {
+		if err := zw.Close(); err != nil {
+			return err
+		}
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// readDump reads a backup, gzipped or not.
+func readDump(path string) (*collectionDump, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var r io.Reader = bufio.NewReader(f)
+	if magic, _ := r.(*bufio.Reader).Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		r = zr
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	// The version is checked first so a dump from a newer version is
+	// reported as such rather than by its first unknown field.
+	version := struct {
+		SchemaVersion int `json:"schema_version"`
+	}{}
+	if err := json.Unmarshal(data, &version); err != nil {
+		return nil, err
+	}
+	if version.SchemaVersion > dumpSchemaVersion {
+		return nil, fmt.Errorf("backup has schema version %d, this version of playita reads up to %d", version.SchemaVersion, dumpSchemaVersion)
+	}
+
+	dump := &collectionDump{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(dump); err != nil {
+		return nil, err
+	}
+	return dump, nil
+}
+
+// validate checks that every row of the dump is well formed and every
+// reference points to a row in the dump.
+func (d *collectionDump) validate() error {
+	if d.SchemaVersion < 1 || d.SchemaVersion > dumpSchemaVersion {
+		return fmt.Errorf("unsupported schema version %d, expected 1 to %d", d.SchemaVersion, dumpSchemaVersion)
+	}
+	for key, value := range d.Settings {
+		if _, ok := defaultSettings[key]; !ok {
+			return fmt.Errorf("unknown setting %q", key)
+		}
+		if err := validateSetting(key, value); err != nil {
+			return err
+		}
+	}
+
+	// uniqueGuid checks that no two rows of a kind share a Guid.
+	guids := map[string]bool{}
+	uniqueGuid := func(kind string, guid string) error {
+		if guid == "" {
+			return nil
+		}
+		if guids[kind+" "+guid] {
+			return fmt.Errorf("%s guid %s appears twice", kind, guid)
+		}
+		guids[kind+" "+guid] = true
+		return nil
+	}
+
+	decks := map[int]bool{}
+	for _, deck := range d.Decks {
+		if decks[deck.Id] {
+			return fmt.Errorf("deck id %d appears twice", deck.Id)
+		}
+		if err := uniqueGuid("deck", deck.Guid); err != nil {
+			return err
+		}
+		decks[deck.Id] = true
+		if strings.TrimSpace(deck.Name) == "" {
+			return fmt.Errorf("deck id %d has no name", deck.Id)
+		}
+		for key, value := range deck.Settings {
+			if !slices.Contains(deckSettings, key) {
+				return fmt.Errorf("deck id %d: %s can't be set per deck", deck.Id, key)
+			}
+			if err := validateSetting(key, value); err != nil {
+				return fmt.Errorf("deck id %d: %w", deck.Id, err)
+			}
+		}
+	}
+
+	noteTypes := map[int]bool{}
+	for _, nt := range d.NoteTypes {
+		if noteTypes[nt.Id] {
+			return fmt.Errorf("note type id %d appears twice", nt.Id)
+		}
+		if err := uniqueGuid("note type", nt.Guid); err != nil {
+			return err
+		}
+		noteTypes[nt.Id] = true
+		noteType := nt.noteType()
+		if err := noteType.validate(); err != nil {
+			return fmt.Errorf("note type %q: %w", nt.Name, err)
+		}
+	}
+
+	notes := map[int]bool{}
+	for _, note := range d.Notes {
+		if notes[note.Id] {
+			return fmt.Errorf("note id %d appears twice", note.Id)
+		}
+		if err := uniqueGuid("note", note.Guid); err != nil {
+			return err
+		}
+		notes[note.Id] = true
+		if !decks[note.DeckId] {
+			return fmt.Errorf("note id %d belongs to missing deck id %d", note.Id, note.DeckId)
+		}
+		if !noteTypes[note.NoteTypeId] {
+			return fmt.Errorf("note id %d has missing note type id %d", note.Id, note.NoteTypeId)
+		}
+	}
+
+	cards := map[int]bool{}
+	for _, card := range d.Cards {
+		if cards[card.Id] {
+			return fmt.Errorf("card id %d appears twice", card.Id)
+		}
+		if err := uniqueGuid("card", card.Guid); err != nil {
+			return err
+		}
+		cards[card.Id] = true
+		if !decks[card.DeckId] {
+			return fmt.Errorf("card id %d belongs to missing deck id %d", card.Id, card.DeckId)
+		}
+		if card.NoteId != 0 && !notes[card.NoteId] {
+			return fmt.Errorf("card id %d belongs to missing note id %d", card.Id, card.NoteId)
+		}
+		if card.Interval < 0 || card.Repetition < 0 || card.Lapses < 0 || card.EaseFactor < 1.3 || card.Flag < 0 || card.Flag >= len(flagNames) || card.ReviewDate.IsZero() {
+			return fmt.Errorf("card id %d has invalid scheduling", card.Id)
+		}
+	}
+
+	for _, review := range d.Reviews {
+		if err := uniqueGuid("review", review.Guid); err != nil {
+			return err
+		}
+		if !cards[review.CardId] {
+			return fmt.Errorf("review of missing card id %d", review.CardId)
+		}
+		if review.Grade < 1 || review.Grade > 5 {
+			return fmt.Errorf("review of card id %d has invalid grade %d", review.CardId, review.Grade)
+		}
+		if review.Duration < 0 {
+			return fmt.Errorf("review of card id %d has negative duration", review.CardId)
+		}
+	}
+	return nil
+}
+
+// restoreDump loads a validated dump in one transaction. With replace the
+// current collection is deleted first and ids and sync identities are
+// kept, otherwise every row gets a new id and Guid.
+func (db *DB) restoreDump(d *collectionDump, replace bool) error {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	current := []NoteType{}
+	if !replace {
+		if current, err = queryNoteTypes(tx); err != nil {
+			return err
+		}
+	}
+
+	var lastLog int64
+	if replace {
+		if err := tx.QueryRow("SELECT COALESCE(MAX(Id), 0) FROM ChangeLog").Scan(&lastLog); err != nil {
+			return err
+		}
+		for _, table := range []string{"Reviews", "Cards", "Notes", "CardTemplates", "NoteTypes", "DeckSettings", "Decks", "Settings"} {
+			if _, err := tx.Exec("DELETE FROM " + table + ";"); err != nil {
+				return err
+			}
+		}
+	}
+
+	settingStmt := "INSERT OR IGNORE INTO Settings(Key, Value) VALUES (?, ?)"
+	for key, value := range d.Settings {
+		if _, err := tx.Exec(settingStmt, key, value); err != nil {
+			return err
+		}
+	}
+
+	// insert adds a row, keeping its id and sync identity when replacing,
+	// and returns its id. Rows inserted with a Modified time aren't logged
+	// as changes.
+	insert := func(id int, identity syncDump, stmt string, args ...any) (int, error) {
+		if replace {
+			columns, values := []string{"Id"}, []any{id}
+			if identity.Guid != "" && identity.Modified != nil {
+				columns = append(columns, "Guid", "Modified")
+				values = append(values, identity.Guid, identity.Modified.UTC())
+			}
+			stmt = strings.Replace(stmt, "(", "("+strings.Join(columns, ", ")+", ", 1)
+			stmt = strings.Replace(stmt, "VALUES (", "VALUES ("+strings.Repeat("?, ", len(columns)), 1)
+			args = append(values, args...)
+		}
+		res, err := tx.Exec(stmt, args...)
+		if err != nil {
+			return 0, err
+		}
+		newId, err := res.LastInsertId()
+		return int(newId), err
+	}
+
+	deckIds := map[int]int{}
+	for _, deck := range d.Decks {
+		if !replace {
+			var existing int
+			err := tx.QueryRow("SELECT Id FROM Decks WHERE Name = ? ORDER BY Id LIMIT 1", deck.Name).Scan(&existing)
+			if err == nil {
+				deckIds[deck.Id] = existing
+			} else if !errors.Is(err, sql.ErrNoRows) {
+				return err
+			}
+		}
+		if _, ok := deckIds[deck.Id]; !ok {
+			id, err := insert(deck.Id, deck.syncDump, "INSERT INTO Decks(Name) VALUES (?)", deck.Name)
+			if err != nil {
+				return err
+			}
+			deckIds[deck.Id] = id
+		}
+		for key, value := range deck.Settings {
+			if _, err := tx.Exec("INSERT OR IGNORE INTO DeckSettings(DeckId, Key, Value) VALUES (?, ?, ?)", deckIds[deck.Id], key, value); err != nil {
+				return err
+			}
+		}
+	}
+
+	noteTypeIds := map[int]int{}
+	for _, dumped := range d.NoteTypes {
+		nt := dumped.noteType()
+		if i := slices.IndexFunc(current, func(c NoteType) bool { return c.Name == nt.Name }); i >= 0 {
+			if sameNoteType(current[i], nt) {
+				noteTypeIds[nt.Id] = current[i].Id
+				continue
+			}
+			nt.Name = uniqueNoteTypeName(current, nt.Name+" (restored)")
+		}
+		fields, err := json.Marshal(nt.Fields)
+		if err != nil {
+			return err
+		}
+		id, err := insert(nt.Id, dumped.syncDump, "INSERT INTO NoteTypes(Name, Fields) VALUES (?, ?)", nt.Name, string(fields))
+		if err != nil {
+			return err
+		}
+		noteTypeIds[nt.Id] = id
+		for ord, t := range nt.Templates {
+			stmt := "INSERT INTO CardTemplates(NoteTypeId, Ord, Name, Front, Back) VALUES (?, ?, ?, ?, ?)"
+			if _, err := tx.Exec(stmt, id, ord, t.Name, t.Front, t.Back); err != nil {
+				return err
+			}
+		}
+		nt.Id = id
+		current = append(current, nt)
+	}
+
+	noteIds := map[int]int{}
+	for _, note := range d.Notes {
+		fields, err := json.Marshal(note.Fields)
+		if err != nil {
+			return err
+		}
+		id, err := insert(note.Id, note.syncDump, "INSERT INTO Notes(DeckId, NoteTypeId, Fields, Tags) VALUES (?, ?, ?, ?)", deckIds[note.DeckId], noteTypeIds[note.NoteTypeId], string(fields), note.Tags)
+		if err != nil {
+			return err
+		}
+		noteIds[note.Id] = id
+	}
+
+	cardIds := map[int]int{}
+	for _, card := range d.Cards {
+		var buriedUntil any
+		if card.BuriedUntil != nil {
+			buriedUntil = card.BuriedUntil.UTC()
+		}
+		stmt := "INSERT INTO Cards(DeckId, Front, Back, Interval, EaseFactor, Repetition, ReviewDate, NoteId, Ord, BuriedUntil, Suspended, Flag, Lapses) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+		id, err := insert(card.Id, card.syncDump, stmt, deckIds[card.DeckId], card.Front, card.Back, card.Interval, card.EaseFactor, card.Repetition, card.ReviewDate.UTC(), noteIds[card.NoteId], card.Ord, buriedUntil, card.Suspended, card.Flag, card.Lapses)
+		if err != nil {
+			return err
+		}
+		cardIds[card.Id] = id
+	}
+
+	for _, review := range d.Reviews {
+		// Reviews inserted without a Guid are given one and logged.
+		var guid any
+		if replace && review.Guid != "" {
+			guid = review.Guid
+		}
+		stmt := "INSERT INTO Reviews(CardId, ReviewedAt, Grade, Interval, EaseFactor, Duration, Guid) VALUES (?, ?, ?, ?, ?, ?, ?)"
+		if _, err := tx.Exec(stmt, cardIds[review.CardId], review.ReviewedAt.UTC(), review.Grade, review.Interval, review.EaseFactor, review.Duration, guid); err != nil {
+			return err
+		}
+	}
+
+	if replace {
+		// Replacing is local: the deletes it logged would delete the rows
+		// on every other device. Pulling everything again brings back what
+		// they changed after the backup was taken.
+		if _, err := tx.Exec("DELETE FROM ChangeLog WHERE Id > ? AND Deleted = 1", lastLog); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("DELETE FROM SyncState WHERE Key = 'pulled'"); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func sameNoteType(a NoteType, b NoteType) bool {
+	return slices.Equal(a.Fields, b.Fields) && slices.Equal(a.Templates, b.Templates)
+}
+
+func uniqueNoteTypeName(noteTypes []NoteType, name string) string {
+	taken := func(name string) bool {
+		return slices.ContainsFunc(noteTypes, func(nt NoteType) bool { return nt.Name == name })
+	}
+	unique := name
+	for i := 2; taken(unique); i++ {
+		unique = fmt.Sprintf("%s %d", name, i)
+	}
+	return unique
+}
@@ -0,0 +1,169 @@
+package cmd
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+func TestBackupRoundTrip(t *testing.T) {
+	now := localTime(2024, 5, 10, 9, 0)
+	db, _ := newTestDb(t, now)
+	card := addTestCard(t, db, addTestDeck(t, db, "Spanish"), "hola", "hello")
+	card.updateCard(5, 1500*time.Millisecond, db)
+
+	dump, err := db.dumpCollection()
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "backup.json.gz")
+	if err := writeDump(path, dump); err != nil {
+		t.Fatal(err)
+	}
+	read, err := readDump(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := read.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	restored, _ := newTestDb(t, now)
+	logged := countRows(t, restored, "ChangeLog")
+	if err := restored.restoreDump(read, true); err != nil {
+		t.Fatal(err)
+	}
+	cards := restored.getAllCards()
+	if len(cards) != 1 || cards[0].Front != "hola" || cards[0].Interval != 1 {
+		t.Fatalf("restored cards %v", cards)
+	}
+	var duration int64
+	if err := restored.db.QueryRow("SELECT Duration FROM Reviews").Scan(&duration); err != nil {
+		t.Fatal(err)
+	}
+	if duration != 1500 {
+		t.Errorf("restored review took %dms, want 1500", duration)
+	}
+	for _, table := range syncTables {
+		original, restoredGuids := []string{}, []string{}
+		for db, guids := range map[*DB]*[]string{db: &original, restored: &restoredGuids} {
+			err := scanEach(db.db, "SELECT Guid FROM "+table+" ORDER BY Id", func(rows *sql.Rows) error {
+				var guid string
+				err := rows.Scan(&guid)
+				*guids = append(*guids, guid)
+				return err
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if !slices.Equal(original, restoredGuids) {
+			t.Errorf("%s guids %v restored as %v", table, original, restoredGuids)
+		}
+	}
+	if changes := countRows(t, restored, "ChangeLog") - logged; changes != 0 {
+		t.Errorf("replacing logged %d changes", changes)
+	}
+}
+
+func TestReadDumpVersions(t *testing.T) {
+	tests := []struct {
+		name string
+		dump string
+		err  string
+	}{
+		{"version 1", `{"schema_version": 1, "settings": {}, "decks": [], "note_types": [], "notes": [], "cards": [], "reviews": []}`, ""},
+		{"newer version", `{"schema_version": 99, "settings": {}, "something_new": true}`, "schema version 99"},
+		{"unknown field", `{"schema_version": 1, "something_new": true}`, "unknown field"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "backup.json")
+			if err := os.WriteFile(path, []byte(tt.dump), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			dump, err := readDump(path)
+			if err == nil {
+				err = dump.validate()
+			}
+			if tt.err == "" && err != nil {
+				t.Errorf("unexpected error %v", err)
+			}
+			if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Errorf("error %v, want one mentioning %q", err, tt.err)
+			}
+		})
+	}
+}
+
+// backupAndRestore writes a backup of db, checks it and restores it into
+// a new collection.
+func backupAndRestore(t *testing.T, db *DB) *DB {
+	t.Helper()
+	dump, err := db.dumpCollection()
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "backup.json")
+	if err := writeDump(path, dump); err != nil {
+		t.Fatal(err)
+	}
+	read, err := readDump(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := read.validate(); err != nil {
+		t.Fatal(err)
+	}
+	restored, _ := newTestDb(t, db.now())
+	if err := restored.restoreDump(read, true); err != nil {
+		t.Fatal(err)
+	}
+	return restored
+}
+
+func TestBackupAfterDeleting(t *testing.T) {
+	now := localTime(2024, 5, 10, 9, 0)
+	tests := []struct {
+		name   string
+		delete func(t *testing.T, db *DB, deleted *BaseCard)
+	}{
+		{"deck", func(t *testing.T, db *DB, deleted *BaseCard) {
+			if err := db.deleteDeck(deleted.DeckId); err != nil {
+				t.Fatal(err)
+			}
+		}},
+		{"card", func(t *testing.T, db *DB, deleted *BaseCard) {
+			if err := db.deleteNoteOfCard(deleted.Id); err != nil {
+				t.Fatal(err)
+			}
+		}},
+		// Older versions only deleted the deck row.
+		{"deck row only", func(t *testing.T, db *DB, deleted *BaseCard) {
+			mustExec(t, db, "DELETE FROM Decks WHERE Id = ?", deleted.DeckId)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, _ := newTestDb(t, now)
+			kept := addTestCard(t, db, addTestDeck(t, db, "Spanish"), "hola", "hello")
+			deleted := addTestCard(t, db, addTestDeck(t, db, "French"), "bonjour", "hello")
+			kept.updateCard(5, time.Second, db)
+			deleted.updateCard(5, time.Second, db)
+
+			tt.delete(t, db, deleted)
+			restored := backupAndRestore(t, db)
+			if cards := restored.getAllCards(); len(cards) != 1 || cards[0].Id != kept.Id {
+				t.Errorf("restored cards %v, want only %d", cards, kept.Id)
+			}
+			if reviews := countRows(t, restored, "Reviews"); reviews != 1 {
+				t.Errorf("restored %d reviews, want 1", reviews)
+			}
+		})
+	}
+}
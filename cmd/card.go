@@ -0,0 +1,423 @@
+package cmd
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/exp/slices"
+)
+
+// cardCmd represents the card command
+var cardCmd = &cobra.Command{
+	Use:   "card",
+	Short: "Manage cards without opening the menu",
+}
+
+var cardAddCmd = &cobra.Command{
+	Use:   "add",
+	Short: "Add a note to a deck, creating its cards",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		deckName, _ := cmd.Flags().GetString("deck")
+
+		db := openDb()
+		nt := parseCardTypeFlag(cmd, db)
+		fields := map[string]string{}
+		applyFieldFlags(cmd, nt, fields)
+		if useEditor, _ := cmd.Flags().GetBool("editor"); useEditor {
+			var err error
+			if fields, err = editNoteFields(nt, fields); err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		tags, _ := cmd.Flags().GetStringSlice("tags")
+		deck := db.findOrCreateDeck(deckName)
+		db.addNewNote(&Note{DeckId: deck.Id, NoteTypeId: nt.Id, Fields: fields, Tags: formatTags(tags)})
+		fmt.Println()
+	},
+}
+
+var cardEditCmd = &cobra.Command{
+	Use:   "edit <card id>",
+	Short: "Edit the fields of the note of a card",
+	Long: `
+Edit the fields of the note a card was rendered from. Every card of the
+note, such as the reverse card, is updated to match. Without --front,
+--back, --field or --tags the note is opened in $VISUAL or $EDITOR.
+	`,
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: completeCardIds,
+	Run: func(cmd *cobra.Command, args []string) {
+		db := openDb()
+		card, err := db.getCard(parseCardId(args[0]))
+		if err != nil {
+			log.Fatal(err)
+		}
+		note, err := db.getNote(card.NoteId)
+		if err != nil {
+			log.Fatal(err)
+		}
+		nt, err := db.getNoteType(note.NoteTypeId)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		changed := applyFieldFlags(cmd, nt, note.Fields)
+		if cmd.Flags().Changed("tags") {
+			tags, _ := cmd.Flags().GetStringSlice("tags")
+			note.Tags = formatTags(tags)
+			changed = true
+		}
+		if !changed {
+			if note.Fields, err = editNoteFields(nt, note.Fields); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if err := db.updateNote(note); err != nil {
+			log.Fatalf("Failed to edit card id: %v with error: %v", card.Id, err)
+		}
+		fmt.Println("Card edited")
+	},
+}
+
+var cardSuspendCmd = &cobra.Command{
+	Use:               "suspend <card id>...",
+	Short:             "Exclude cards from reviews until they are unsuspended",
+	Args:              cobra.MinimumNArgs(1),
+	ValidArgsFunction: completeCardIds,
+	Run: func(cmd *cobra.Command, args []string) {
+		db := openDb()
+		for _, arg := range args {
+			db.setSuspended(db.mustGetCard(arg).Id, true)
+		}
+		fmt.Println("Cards suspended")
+	},
+}
+
+var cardUnsuspendCmd = &cobra.Command{
+	Use:               "unsuspend <card id>...",
+	Short:             "Return suspended cards to reviews",
+	Args:              cobra.MinimumNArgs(1),
+	ValidArgsFunction: completeCardIds,
+	Run: func(cmd *cobra.Command, args []string) {
+		db := openDb()
+		for _, arg := range args {
+			db.setSuspended(db.mustGetCard(arg).Id, false)
+		}
+		fmt.Println("Cards unsuspended")
+	},
+}
+
+var cardBuryCmd = &cobra.Command{
+	Use:               "bury <card id>...",
+	Short:             "Hide cards from reviews until tomorrow",
+	Args:              cobra.MinimumNArgs(1),
+	ValidArgsFunction: completeCardIds,
+	Run: func(cmd *cobra.Command, args []string) {
+		days, _ := cmd.Flags().GetInt("days")
+		if days < 1 {
+			log.Fatal("--days must be at least 1")
+		}
+		db := openDb()
+		for _, arg := range args {
+			db.buryCard(db.mustGetCard(arg).Id, days)
+		}
+		fmt.Println("Cards buried")
+	},
+}
+
+var cardFlagCmd = &cobra.Command{
+	Use:               "flag <card id> <" + strings.Join(flagNames, "|") + ">",
+	Short:             "Flag a card with a colour, or remove its flag with none",
+	Args:              cobra.ExactArgs(2),
+	ValidArgsFunction: completeFlagCard,
+	Run: func(cmd *cobra.Command, args []string) {
+		flag := slices.Index(flagNames, strings.ToLower(args[1]))
+		if flag < 0 {
+			log.Fatalf("Unknown flag %q, expected one of: %s\n", args[1], strings.Join(flagNames, ", "))
+		}
+		db := openDb()
+		db.setFlag(db.mustGetCard(args[0]), flag)
+		fmt.Println("Card flagged")
+	},
+}
+
+var cardListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List cards with their scheduling",
+	Long: `
+List the cards of every deck, or of one with --deck. --due only lists the
+cards due today.
+
+With --output json or jsonl each card is written as:
+
+  {"schema_version": 1, "id": 7, "deck_id": 1, "note_id": 4, "ord": 0,
+   "front": "hola", "back": "hello", "interval": 6, "ease_factor": 2.5,
+   "repetitions": 2, "lapses": 0, "due": "2024-05-01", "suspended": false,
+   "buried_until": null, "flag": "none"}
+
+due is the study day the card is due on and buried_until an RFC 3339 time.
+	`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		deckName, _ := cmd.Flags().GetString("deck")
+		due, _ := cmd.Flags().GetBool("due")
+
+		db := openDb()
+		var cards []BaseCard
+		if due {
+			filter := SessionFilter{}
+			if deckName != "" {
+				filter.DeckIds = []int{db.mustGetDeck(deckName).Id}
+			}
+			cards = db.getSessionCards(filter).Cards
+		} else if deckName != "" {
+			cards = getCardsFromDeck(db, db.mustGetDeck(deckName).Id).Cards
+		} else {
+			cards = db.getAllCards()
+		}
+
+		records := []cardRecord{}
+		for _, card := range cards {
+			records = append(records, db.newCardRecord(card))
+		}
+		printRecords(records, cardOutputColumns)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(cardCmd)
+	cardCmd.AddCommand(cardAddCmd, cardEditCmd, cardSuspendCmd, cardUnsuspendCmd, cardBuryCmd, cardFlagCmd, cardListCmd)
+
+	cardListCmd.Flags().String("deck", "", "only list cards of this deck")
+	cardListCmd.Flags().Bool("due", false, "only list cards due today")
+
+	cardBuryCmd.Flags().Int("days", 1, "number of days to hide the cards for")
+
+	cardAddCmd.Flags().String("deck", "", "name of the deck, created when it does not exist")
+	addCardTypeFlag(cardAddCmd)
+	addFieldFlags(cardAddCmd)
+	cardAddCmd.Flags().Bool("editor", false, "write the fields in $VISUAL or $EDITOR")
+	cardAddCmd.MarkFlagRequired("deck")
+
+	addFieldFlags(cardEditCmd)
+}
+
+func addCardTypeFlag(cmd *cobra.Command) {
+	cmd.Flags().String("type", "basic", "note type: basic, reversed (adds a linked Back→Front card) or the name of a note type")
+}
+
+func addFieldFlags(cmd *cobra.Command) {
+	cmd.Flags().String("front", "", "value of the Front field")
+	cmd.Flags().String("back", "", "value of the Back field")
+	cmd.Flags().StringArray("field", []string{}, "field value as Name=value, can be repeated")
+	cmd.Flags().StringSlice("tags", []string{}, "comma separated tags of the note")
+}
+
+func parseCardTypeFlag(cmd *cobra.Command, db *DB) *NoteType {
+	value, _ := cmd.Flags().GetString("type")
+	name, ok := cardTypeFlags[strings.ToLower(value)]
+	if !ok {
+		name = value
+	}
+	nt, err := db.getNoteTypeByName(name)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return nt
+}
+
+// applyFieldFlags copies the --front, --back and --field values into
+// fields and reports whether any was given.
+func applyFieldFlags(cmd *cobra.Command, nt *NoteType, fields map[string]string) bool {
+	values := map[string]string{}
+	if cmd.Flags().Changed("front") {
+		values["Front"], _ = cmd.Flags().GetString("front")
+	}
+	if cmd.Flags().Changed("back") {
+		values["Back"], _ = cmd.Flags().GetString("back")
+	}
+	pairs, _ := cmd.Flags().GetStringArray("field")
+	for _, pair := range pairs {
+		name, value, found := strings.Cut(pair, "=")
+		if !found {
+			log.Fatalf("Invalid field %q, expected Name=value\n", pair)
+		}
+		values[name] = value
+	}
+
+	for name, value := range values {
+		if !slices.Contains(nt.Fields, name) {
+			log.Fatalf("Note type %s has no field %q, fields are: %s\n", nt.Name, name, strings.Join(nt.Fields, ", "))
+		}
+		fields[name] = value
+	}
+	return len(values) > 0
+}
+
+func (db *DB) mustGetCard(arg string) *BaseCard {
+	card, err := db.getCard(parseCardId(arg))
+	if err != nil {
+		log.Fatal(err)
+	}
+	return card
+}
+
+func parseCardId(arg string) int {
+	var cardId int
+	if _, err := fmt.Sscan(arg, &cardId); err != nil || cardId <= 0 {
+		log.Fatalf("Invalid card id %q\n", arg)
+	}
+	return cardId
+}
+
+func (db *DB) getDeckByName(name string) (*BaseDeck, error) {
+	deck := BaseDeck{}
+	err := db.db.QueryRow("SELECT Id, Name FROM Decks WHERE Name = ? ORDER BY Id LIMIT 1", name).Scan(&deck.Id, &deck.Name)
+	if err != nil {
+		return nil, err
+	}
+	return &deck, nil
+}
+
+func (db *DB) mustGetDeck(name string) *BaseDeck {
+	deck, err := db.getDeckByName(name)
+	if err != nil {
+		log.Fatalf("Deck %q not found\n", name)
+	}
+	return deck
+}
+
+func (db *DB) findOrCreateDeck(name string) *BaseDeck {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		log.Fatal("name of deck cannot be empty")
+	}
+
+	deck, err := db.getDeckByName(name)
+	if err == nil {
+		return deck
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		log.Fatal("Error querying for deck ", err)
+	}
+
+	db.addNewDeck(&BaseDeck{Name: name})
+	fmt.Println()
+	deck, err = db.getDeckByName(name)
+	if err != nil {
+		log.Fatal("Error querying for deck ", err)
+	}
+	return deck
+}
+
+// flagNames are the colours a card can be flagged with, index 0 means no flag.
+var flagNames = []string{"none", "red", "orange", "green", "blue"}
+
+var flagColors = []string{"", "\x1b[31m", "\x1b[38;5;208m", "\x1b[32m", "\x1b[34m"}
+
+func flagMarker(flag int) string {
+	if flag <= 0 || flag >= len(flagNames) {
+		return ""
+	}
+	if !colorEnabled() {
+		return "[" + flagNames[flag] + " flag]"
+	}
+	return flagColors[flag] + "⚑ " + flagNames[flag] + ansiDefaultColor
+}
+
+func selectFlag() int {
+	return slices.Index(flagNames, selectOption(flagNames, "Flag"))
+}
+
+func (db *DB) setSuspended(cardId int, suspended bool) {
+	if _, err := db.db.Exec("UPDATE Cards SET Suspended = ? WHERE Id = ?;", suspended, cardId); err != nil {
+		fmt.Printf("Failed to update card Id: %v with error: %v", cardId, err)
+	}
+}
+
+// buryCard hides a card until the start of the study day days from today.
+func (db *DB) buryCard(cardId int, days int) {
+	buriedUntil := db.dayStart(db.today().AddDate(0, 0, days))
+	if _, err := db.db.Exec("UPDATE Cards SET BuriedUntil = ? WHERE Id = ?;", buriedUntil, cardId); err != nil {
+		fmt.Printf("Failed to bury card Id: %v with error: %v", cardId, err)
+	}
+}
+
+func (db *DB) setFlag(card *BaseCard, flag int) {
+	if _, err := db.db.Exec("UPDATE Cards SET Flag = ? WHERE Id = ?;", flag, card.Id); err != nil {
+		fmt.Printf("Failed to flag card Id: %v with error: %v", card.Id, err)
+		return
+	}
+	card.Flag = flag
+}
+
+type cardRecord struct {
+	schema
+	Id          int     `json:"id"`
+	DeckId      int     `json:"deck_id"`
+	NoteId      int     `json:"note_id"`
+	Ord         int     `json:"ord"`
+	Front       string  `json:"front"`
+	Back        string  `json:"back"`
+	Interval    int     `json:"interval"`
+	EaseFactor  float32 `json:"ease_factor"`
+	Repetitions int     `json:"repetitions"`
+	Lapses      int     `json:"lapses"`
+	Due         string  `json:"due"`
+	Suspended   bool    `json:"suspended"`
+	BuriedUntil *string `json:"buried_until"`
+	Flag        string  `json:"flag"`
+}
+
+func (db *DB) newCardRecord(c BaseCard) cardRecord {
+	record := cardRecord{
+		schema:      currentSchema,
+		Id:          c.Id,
+		DeckId:      c.DeckId,
+		NoteId:      c.NoteId,
+		Ord:         c.Ord,
+		Front:       c.Front,
+		Back:        c.Back,
+		Interval:    c.Interval,
+		EaseFactor:  c.EaseFactor,
+		Repetitions: c.Repetition,
+		Lapses:      c.Lapses,
+		Due:         dayOfDue(c.ReviewDate).Format("2006-01-02"),
+		Suspended:   c.Suspended,
+		Flag:        flagNames[0],
+	}
+	if c.BuriedUntil.After(db.now()) {
+		buriedUntil := c.BuriedUntil.Format(time.RFC3339)
+		record.BuriedUntil = &buriedUntil
+	}
+	if c.Flag > 0 && c.Flag < len(flagNames) {
+		record.Flag = flagNames[c.Flag]
+	}
+	return record
+}
+
+var cardOutputColumns = []outputColumn[cardRecord]{
+	{"id", func(c cardRecord) string { return strconv.Itoa(c.Id) }},
+	{"deck_id", func(c cardRecord) string { return strconv.Itoa(c.DeckId) }},
+	{"front", func(c cardRecord) string {
+		if tableOutput() {
+			return summarize(c.Front, 40)
+		}
+		return c.Front
+	}},
+	{"interval", func(c cardRecord) string { return strconv.Itoa(c.Interval) }},
+	{"ease_factor", func(c cardRecord) string { return strconv.FormatFloat(float64(c.EaseFactor), 'f', 2, 32) }},
+	{"repetitions", func(c cardRecord) string { return strconv.Itoa(c.Repetitions) }},
+	{"lapses", func(c cardRecord) string { return strconv.Itoa(c.Lapses) }},
+	{"due", func(c cardRecord) string { return c.Due }},
+	{"suspended", func(c cardRecord) string { return strconv.FormatBool(c.Suspended) }},
+	{"flag", func(c cardRecord) string { return c.Flag }},
+}
@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// Clock tells the current time. Everything that depends on the time of day
+// asks the clock of the DB so scheduling can be simulated and tested.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// offsetClock runs at normal speed, shifted by a fixed offset.
+type offsetClock struct {
+	offset time.Duration
+}
+
+func (c offsetClock) Now() time.Time {
+	return time.Now().Add(c.offset)
+}
+
+// nowOverride holds the hidden --now flag.
+var nowOverride string
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&nowOverride, "now", "", "pretend the current date is YYYY-MM-DD or an RFC 3339 time")
+	rootCmd.PersistentFlags().MarkHidden("now")
+}
+
+// parseNow turns the value of --now into a clock that starts at that time.
+// A date keeps the current time of day.
+func parseNow(value string, now time.Time) (Clock, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return offsetClock{offset: t.Sub(now)}, nil
+	}
+	day, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --now %q, expected YYYY-MM-DD or an RFC 3339 time", value)
+	}
+	local := now.In(time.Local)
+	t := time.Date(day.Year(), day.Month(), day.Day(), local.Hour(), local.Minute(), local.Second(), local.Nanosecond(), time.Local)
+	return offsetClock{offset: t.Sub(now)}, nil
+}
+
+func (db *DB) now() time.Time {
+	return db.clock.Now()
+}
+
+// clockFromFlags returns the clock selected on the command line.
+func clockFromFlags() Clock {
+	if nowOverride == "" {
+		return systemClock{}
+	}
+	clock, err := parseNow(nowOverride, time.Now())
+	if err != nil {
+		log.Fatal(err)
+	}
+	return clock
+}
@@ -0,0 +1,240 @@
+package cmd
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestStudyDay(t *testing.T) {
+	db, _ := newTestDb(t, localTime(2024, 5, 10, 12, 0))
+
+	tests := []struct {
+		name     string
+		rollover string
+		now      time.Time
+		day      time.Time
+	}{
+		{"before rollover", "4", localTime(2024, 5, 10, 3, 59), localTime(2024, 5, 9, 0, 0)},
+		{"at rollover", "4", localTime(2024, 5, 10, 4, 0), localTime(2024, 5, 10, 0, 0)},
+		{"evening", "4", localTime(2024, 5, 10, 23, 30), localTime(2024, 5, 10, 0, 0)},
+		{"midnight rollover", "0", localTime(2024, 5, 10, 0, 0), localTime(2024, 5, 10, 0, 0)},
+		{"late rollover", "23", localTime(2024, 5, 10, 22, 0), localTime(2024, 5, 9, 0, 0)},
+		{"utc time", "4", time.Date(2024, 5, 10, 2, 30, 0, 0, time.UTC), localTime(2024, 5, 10, 0, 0)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mustSetSetting(t, db, "rollover-hour", tt.rollover)
+			if got := db.studyDay(tt.now); !got.Equal(tt.day) {
+				t.Errorf("studyDay(%v) = %v, want %v", tt.now, got, tt.day)
+			}
+		})
+	}
+
+	// A due date stays on its day whatever the rollover hour, even past
+	// noon where studyDay of the same moment is the day before.
+	day := localTime(2024, 5, 10, 0, 0)
+	for _, rollover := range []string{"0", "12", "23"} {
+		t.Run("due date with rollover "+rollover, func(t *testing.T) {
+			mustSetSetting(t, db, "rollover-hour", rollover)
+			if got := dayOfDue(dueOn(day)); !got.Equal(day) {
+				t.Errorf("dayOfDue(dueOn(%v)) = %v", day, got)
+			}
+			if got := dayOfDue(dueOn(day).Add(-time.Hour)); !got.Equal(day.AddDate(0, 0, -1)) {
+				t.Errorf("due an hour before noon is on %v, want the day before as dueCutoff has it", got)
+			}
+		})
+	}
+}
+
+func TestDueCutoff(t *testing.T) {
+	tests := []struct {
+		name      string
+		now       time.Time
+		daysAhead int
+		cutoff    time.Time
+	}{
+		{"before rollover", localTime(2024, 5, 10, 3, 0), 0, localTime(2024, 5, 10, 12, 0)},
+		{"after rollover", localTime(2024, 5, 10, 5, 0), 0, localTime(2024, 5, 11, 12, 0)},
+		{"ahead", localTime(2024, 5, 10, 5, 0), 3, localTime(2024, 5, 14, 12, 0)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, _ := newTestDb(t, tt.now)
+			if got := db.dueCutoff(tt.daysAhead); !got.Equal(tt.cutoff) {
+				t.Errorf("dueCutoff(%d) = %v, want %v", tt.daysAhead, got, tt.cutoff)
+			}
+		})
+	}
+}
+
+func TestUpdateCard(t *testing.T) {
+	now := localTime(2024, 5, 10, 9, 0)
+	today := localTime(2024, 5, 10, 0, 0)
+
+	tests := []struct {
+		name        string
+		rollover    string
+		creditLate  string
+		repetition  int
+		interval    int
+		due         time.Time
+		quality     float32
+		recalled    bool
+		wantRep     int
+		wantLapses  int
+		wantDue     time.Time
+		wantInteval int
+	}{
+		{"new card recalled", "4", "true", 0, 0, today, 5, true, 1, 0, today.AddDate(0, 0, 1), 1},
+		{"second review", "4", "true", 1, 1, today, 4, true, 2, 0, today.AddDate(0, 0, 6), 6},
+		{"on time", "4", "true", 2, 6, today, 5, true, 3, 0, today.AddDate(0, 0, 16), 16},
+		{"failed keeps due date", "4", "true", 3, 16, today, 2, false, 0, 1, today, 1},
+		{"late recalled with 5", "4", "true", 2, 10, today.AddDate(0, 0, -4), 5, true, 3, 0, today.AddDate(0, 0, 36), 36},
+		{"late recalled with 4", "4", "true", 2, 10, today.AddDate(0, 0, -4), 4, true, 3, 0, today.AddDate(0, 0, 21), 21},
+		{"late without credit", "4", "false", 2, 10, today.AddDate(0, 0, -4), 5, true, 3, 0, today.AddDate(0, 0, 22), 26},
+		{"reviewed ahead", "4", "true", 2, 10, today.AddDate(0, 0, 3), 5, true, 3, 0, today.AddDate(0, 0, 18), 18},
+		// At 09:00 with rollover 18 the study day is still the 9th.
+		{"rollover after noon", "18", "false", 0, 0, today.AddDate(0, 0, -1), 4, true, 1, 0, today, 1},
+		{"rollover after noon on time", "18", "false", 1, 1, today.AddDate(0, 0, -1), 4, true, 2, 0, today.AddDate(0, 0, 5), 6},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, _ := newTestDb(t, now)
+			mustSetSetting(t, db, "interval-fuzz", "0")
+			mustSetSetting(t, db, "rollover-hour", tt.rollover)
+			mustSetSetting(t, db, "credit-late-reviews", tt.creditLate)
+			card := addTestCard(t, db, addTestDeck(t, db, "Deck"), "front", "back")
+			setSchedule(t, db, card, tt.repetition, tt.interval, 2.5, dueOn(tt.due))
+
+			if got, _ := card.updateCard(tt.quality, time.Second, db); got != tt.recalled {
+				t.Errorf("updateCard() = %v, want %v", got, tt.recalled)
+			}
+			stored, err := db.getCard(card.Id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stored.Repetition != tt.wantRep || stored.Lapses != tt.wantLapses || stored.Interval != tt.wantInteval {
+				t.Errorf("repetition, lapses, interval = %d, %d, %d, want %d, %d, %d", stored.Repetition, stored.Lapses, stored.Interval, tt.wantRep, tt.wantLapses, tt.wantInteval)
+			}
+			if !stored.ReviewDate.Equal(dueOn(tt.wantDue)) {
+				t.Errorf("due %v, want %v", stored.ReviewDate.In(time.Local), dueOn(tt.wantDue).In(time.Local))
+			}
+		})
+	}
+}
+
+func TestUpdateCardLeech(t *testing.T) {
+	tests := []struct {
+		action  string
+		lapses  int
+		leech   bool
+		suspend bool
+	}{
+		{"tag", 6, false, false},
+		{"tag", 7, true, false},
+		{"suspend", 7, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s after %d lapses", tt.action, tt.lapses), func(t *testing.T) {
+			db, _ := newTestDb(t, localTime(2024, 5, 10, 9, 0))
+			mustSetSetting(t, db, "leech-threshold", "8")
+			mustSetSetting(t, db, "leech-action", tt.action)
+			card := addTestCard(t, db, addTestDeck(t, db, "Deck"), "front", "back")
+			card.Lapses = tt.lapses
+
+			pop, leech := card.updateCard(2, time.Second, db)
+			if pop != tt.suspend || leech != tt.leech {
+				t.Errorf("updateCard() = %v, %v, want %v, %v", pop, leech, tt.suspend, tt.leech)
+			}
+		})
+	}
+}
+
+func TestLateCredit(t *testing.T) {
+	tests := []struct {
+		delay   int
+		quality float32
+		credit  int
+	}{
+		{0, 4, 0},
+		{4, 4, 2},
+		{5, 4, 2},
+		{4, 5, 4},
+		{-3, 4, -3},
+		{-3, 5, -3},
+	}
+	for _, tt := range tests {
+		if got := lateCredit(tt.delay, tt.quality); got != tt.credit {
+			t.Errorf("lateCredit(%d, %v) = %d, want %d", tt.delay, tt.quality, got, tt.credit)
+		}
+	}
+}
+
+// TestNowShowsDue checks that the clock --now selects decides which cards
+// are due, both for a date and for an exact time.
+func TestNowShowsDue(t *testing.T) {
+	created := localTime(2024, 5, 10, 9, 0)
+	db, clock := newTestDb(t, created)
+	deckId := addTestDeck(t, db, "Deck")
+	dueToday := addTestCard(t, db, deckId, "today", "back")
+	dueIn3 := addTestCard(t, db, deckId, "in 3 days", "back")
+	setSchedule(t, db, dueIn3, 2, 6, 2.5, dueOn(localTime(2024, 5, 13, 0, 0)))
+	dueIn10 := addTestCard(t, db, deckId, "in 10 days", "back")
+	setSchedule(t, db, dueIn10, 2, 6, 2.5, dueOn(localTime(2024, 5, 20, 0, 0)))
+
+	tests := []struct {
+		now  string
+		want []int
+	}{
+		{"2024-05-10", []int{dueToday.Id}},
+		{"2024-05-13", []int{dueToday.Id, dueIn3.Id}},
+		{"2024-05-20T03:00:00+02:00", []int{dueToday.Id, dueIn3.Id}},
+		{"2024-05-20T04:00:00+02:00", []int{dueToday.Id, dueIn3.Id, dueIn10.Id}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.now, func(t *testing.T) {
+			c, err := parseNow(tt.now, created)
+			if err != nil {
+				t.Fatal(err)
+			}
+			clock.t = created.Add(c.(offsetClock).offset)
+			got := []int{}
+			for _, card := range db.getCardsToReview(deckId).Cards {
+				got = append(got, card.Id)
+			}
+			if !equalInts(got, tt.want) {
+				t.Errorf("due cards %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := parseNow("next week", created); err == nil {
+		t.Error("parseNow accepted an invalid date")
+	}
+}
+
+func TestParseNowKeepsTimeOfDay(t *testing.T) {
+	real := localTime(2024, 5, 10, 9, 30)
+	clock, err := parseNow("2030-01-01", real)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := real.Add(clock.(offsetClock).offset)
+	want := localTime(2030, 1, 1, 9, 30)
+	if !got.Equal(want) {
+		t.Errorf("clock starts at %v, want %v", got, want)
+	}
+}
+
+func equalInts(a []int, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
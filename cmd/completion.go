@@ -0,0 +1,178 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// completionCmd replaces the completion command cobra adds by default, so
+// the bash script can be adjusted for deck names with spaces.
+var completionCmd = &cobra.Command{
+	Use:   "completion bash|zsh|fish|powershell",
+	Short: "Generate the autocompletion script for the specified shell",
+	Long: `
+Print the script that completes commands, flags, deck names and card ids
+for a shell. Load it in the current shell with:
+
+  bash:        source <(playita completion bash)
+  zsh:         source <(playita completion zsh)
+  fish:        playita completion fish | source
+  powershell:  playita completion powershell | Out-String | Invoke-Expression
+
+or add the line to the shell's startup file to load it in every session.
+Deck names are looked up in the collection of the current directory.
+	`,
+	Args:                  cobra.ExactArgs(1),
+	ValidArgs:             []string{"bash", "zsh", "fish", "powershell"},
+	DisableFlagsInUseLine: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		var err error
+		switch args[0] {
+		case "bash":
+			err = writeBashCompletion(rootCmd, os.Stdout)
+		case "zsh":
+			err = rootCmd.GenZshCompletion(os.Stdout)
+		case "fish":
+			err = rootCmd.GenFishCompletion(os.Stdout, true)
+		case "powershell":
+			err = rootCmd.GenPowerShellCompletionWithDesc(os.Stdout)
+		default:
+			log.Fatalf("Unknown shell %q, expected bash, zsh, fish or powershell\n", args[0])
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.CompletionOptions.DisableDefaultCmd = true
+	rootCmd.AddCommand(completionCmd)
+}
+
+// bashCompgen is the line of cobra's bash script that filters completions
+// without descriptions. It splits them on spaces, so bashCompgenQuoted
+// escapes them first, and again afterwards so bash inserts them as one word
+// unless the user opened a quote.
+const (
+	bashCompgen       = `IFS=$'\n' read -ra COMPREPLY -d '' < <(compgen -W "${completions[*]}" -- "$cur")`
+	bashCompgenQuoted = `local quoted=() i
+        for comp in "${completions[@]}"; do quoted+=("$(printf '%q' "$comp")"); done
+        IFS=$'\n' read -ra COMPREPLY -d '' < <(compgen -W "${quoted[*]}" -- "$cur")
+        if [[ $cur != [\"\']* ]]; then
+            for i in "${!COMPREPLY[@]}"; do COMPREPLY[i]=$(printf '%q' "${COMPREPLY[i]}"); done
+        fi`
+)
+
+// writeBashCompletion writes cobra's bash script with bashCompgen quoted. It
+// fails when cobra no longer has that line rather than writing a script
+// that breaks deck names apart.
+func writeBashCompletion(cmd *cobra.Command, w io.Writer) error {
+	var script bytes.Buffer
+	if err := cmd.GenBashCompletionV2(&script, true); err != nil {
+		return err
+	}
+	if !strings.Contains(script.String(), bashCompgen) {
+		return errors.New("the bash completion script of cobra changed, completions can't be quoted")
+	}
+	_, err := io.WriteString(w, strings.Replace(script.String(), bashCompgen, bashCompgenQuoted, 1))
+	return err
+}
+
+// registerCompletions completes the --deck flag of every command with the
+// names of the decks. It runs once all commands have defined their flags.
+func registerCompletions(cmd *cobra.Command) {
+	if flag := cmd.LocalFlags().Lookup("deck"); flag != nil {
+		complete := completeDeckNames
+		if flag.Value.Type() == "stringSlice" {
+			complete = completeDeckNameList
+		}
+		cmd.RegisterFlagCompletionFunc("deck", complete)
+	}
+	for _, child := range cmd.Commands() {
+		registerCompletions(child)
+	}
+}
+
+// completionDb opens the database for completions, returning nil rather
+// than creating one when the current directory has none.
+func completionDb() *DB {
+	if _, err := os.Stat(dbFile + ".db"); err != nil {
+		return nil
+	}
+	db, err := newDb(dbFile, clockFromFlags())
+	if err != nil {
+		return nil
+	}
+	return db
+}
+
+func completeDeckNames(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	db := completionDb()
+	if db == nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	names := []string{}
+	for _, deck := range db.getExistingDecks() {
+		if strings.HasPrefix(deck.Name, toComplete) {
+			names = append(names, deck.Name)
+		}
+	}
+	return names, cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeDeckNameList completes the last name of a comma separated list.
+func completeDeckNameList(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	listed, last := "", toComplete
+	if i := strings.LastIndex(toComplete, ","); i >= 0 {
+		listed, last = toComplete[:i+1], toComplete[i+1:]
+	}
+	names, directive := completeDeckNames(cmd, args, last)
+	completions := []string{}
+	for _, name := range names {
+		if !strings.Contains(","+listed, ","+name+",") {
+			completions = append(completions, listed+name)
+		}
+	}
+	return completions, directive
+}
+
+// completeCardIds completes the first argument with card ids, described by
+// the front of the card.
+func completeCardIds(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 && cmd.Args != nil && cmd.Args(cmd, append(args, "")) != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	db := completionDb()
+	if db == nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	ids := []string{}
+	for _, card := range db.getAllCards() {
+		id := strconv.Itoa(card.Id)
+		if strings.HasPrefix(id, toComplete) {
+			front := strings.Join(strings.Fields(card.Front), " ")
+			ids = append(ids, id+"\t"+front)
+		}
+	}
+	return ids, cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeFlagCard completes the arguments of card flag: a card id, then
+// the name of a flag.
+func completeFlagCard(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	switch len(args) {
+	case 0:
+		return completeCardIds(cmd, args, toComplete)
+	case 1:
+		return flagNames, cobra.ShellCompDirectiveNoFileComp
+	}
+	return nil, cobra.ShellCompDirectiveNoFileComp
+}
@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestBashCompletionQuotesDeckNames completes --deck with the generated
+// script, standing in for bash-completion and for playita itself.
+func TestBashCompletionQuotesDeckNames(t *testing.T) {
+	var script bytes.Buffer
+	if err := writeBashCompletion(rootCmd, &script); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(script.String(), bashCompgenQuoted) || strings.Contains(script.String(), bashCompgen) {
+		t.Fatal("completions aren't quoted")
+	}
+
+	bash, err := exec.LookPath("bash")
+	if err != nil {
+		t.Skip("bash not installed")
+	}
+	path := filepath.Join(t.TempDir(), "playita.bash")
+	if err := os.WriteFile(path, script.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	harness := `source "$1" 2>/dev/null
+_init_completion() { COMPREPLY=(); cur=Span; prev=--deck; words=(playita review --deck Span); cword=3; }
+playita() { printf 'Spanish Verbs\nSpanish\n:4\n'; }
+__start_playita 2>/dev/null
+printf '%s\n' "${COMPREPLY[@]}"`
+	out, err := exec.Command(bash, "-c", harness, "bash", path).Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.TrimSpace(string(out)), `Spanish\ Verbs`+"\nSpanish"; got != want {
+		t.Errorf("completed %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,244 @@
+package cmd
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/exp/slices"
+)
+
+// defaultSettings holds every known setting with its default value.
+var defaultSettings = map[string]string{
+	"bury-siblings":   "false",
+	"use-editor":      "false",
+	"leech-threshold": "8",
+	"leech-action":    "tag",
+	"rollover-hour":   "4",
+	"starting-ease":   "2.5",
+	// interval-modifier multiplies every interval after the second review.
+	"interval-modifier": "1.0",
+	// interval-fuzz moves due dates by up to this percentage of the interval.
+	"interval-fuzz": "5",
+	"load-balance":  "false",
+	// credit-late-reviews counts intervals from the day a card is recalled,
+	// crediting the days it was overdue, instead of from its due date.
+	"credit-late-reviews": "true",
+	// backup-days and backup-weeks are the number of daily and weekly copies
+	// of the database kept, 0 turns them off.
+	"backup-days":  "7",
+	"backup-weeks": "4",
+	// sync-url, sync-user and sync-token tell playita sync which server to
+	// use and as whom.
+	"sync-url":   "",
+	"sync-user":  "",
+	"sync-token": "",
+	// hook-timeout is the number of seconds a hook may run before it is
+	// stopped.
+	"hook-timeout": "5",
+}
+
+// deckSettings lists the settings a deck can override.
+var deckSettings = []string{"starting-ease", "interval-modifier"}
+
+// settingRanges bounds numeric settings, inclusive.
+var settingRanges = map[string][2]int{
+	"rollover-hour": {0, 23},
+	"interval-fuzz": {0, 25},
+	"hook-timeout":  {1, 300},
+}
+
+// settingFloatRanges bounds decimal settings, inclusive.
+var settingFloatRanges = map[string][2]float64{
+	"starting-ease":     {1.3, 5},
+	"interval-modifier": {0.5, 2.5},
+}
+
+// settingChoices restricts settings that only accept a fixed set of values.
+var settingChoices = map[string][]string{
+	"leech-action": {"tag", "suspend"},
+}
+
+// configCmd represents the config command
+var configCmd = &cobra.Command{
+	Use:   "config [key] [value]",
+	Short: "Show or change settings",
+	Long: `
+Show all settings when called without arguments, show a single setting
+when called with a key and change it when called with a key and a value.
+
+With --deck the settings of a deck are shown or changed instead. A deck
+can override starting-ease and interval-modifier; the others always apply
+to every deck.
+	`,
+	Example: `  playita config rollover-hour 5
+  playita config --deck Spanish interval-modifier 1.2`,
+	Args: cobra.MaximumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		deckName, _ := cmd.Flags().GetString("deck")
+		db := openDb()
+
+		deckId := 0
+		keys := make([]string, 0, len(defaultSettings))
+		for key := range defaultSettings {
+			keys = append(keys, key)
+		}
+		if deckName != "" {
+			deck, err := db.getDeckByName(deckName)
+			if err != nil {
+				log.Fatalf("Deck %q not found\n", deckName)
+			}
+			deckId = deck.Id
+			keys = slices.Clone(deckSettings)
+		}
+		get := func(key string) string {
+			if deckId != 0 {
+				return db.getDeckSetting(deckId, key)
+			}
+			return db.getSetting(key)
+		}
+
+		if len(args) == 0 {
+			sort.Strings(keys)
+			for _, key := range keys {
+				fmt.Printf("%s = %s\n", key, get(key))
+			}
+			return
+		}
+
+		if !slices.Contains(keys, args[0]) {
+			if _, ok := defaultSettings[args[0]]; ok {
+				log.Fatalf("%q can't be set per deck\n", args[0])
+			}
+			log.Fatalf("Unknown setting %q\n", args[0])
+		}
+		if len(args) == 1 {
+			fmt.Println(get(args[0]))
+			return
+		}
+		var err error
+		if deckId != 0 {
+			err = db.setDeckSetting(deckId, args[0], args[1])
+		} else {
+			err = db.setSetting(args[0], args[1])
+		}
+		if err != nil {
+			log.Fatal("Failed to save setting ", err)
+		}
+		fmt.Printf("%s = %s\n", args[0], args[1])
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(configCmd)
+
+	configCmd.Flags().String("deck", "", "show or change the settings of this deck")
+}
+
+func (db *DB) getSetting(key string) string {
+	var value string
+	err := db.db.QueryRow("SELECT Value FROM Settings WHERE Key = ?", key).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return defaultSettings[key]
+	}
+	if err != nil {
+		log.Printf("Error occurred whilst reading setting %v - error: %v", key, err)
+		return defaultSettings[key]
+	}
+	return value
+}
+
+func (db *DB) getBoolSetting(key string) bool {
+	value, err := strconv.ParseBool(db.getSetting(key))
+	if err != nil {
+		value, _ = strconv.ParseBool(defaultSettings[key])
+	}
+	return value
+}
+
+func (db *DB) getIntSetting(key string) int {
+	value, err := strconv.Atoi(db.getSetting(key))
+	if err != nil {
+		value, _ = strconv.Atoi(defaultSettings[key])
+	}
+	return value
+}
+
+func (db *DB) getFloatSetting(key string) float64 {
+	value, err := strconv.ParseFloat(db.getSetting(key), 64)
+	if err != nil {
+		value, _ = strconv.ParseFloat(defaultSettings[key], 64)
+	}
+	return value
+}
+
+// getDeckSetting returns the value a deck overrides key with, or the global
+// setting when it doesn't.
+func (db *DB) getDeckSetting(deckId int, key string) string {
+	var value string
+	err := db.db.QueryRow("SELECT Value FROM DeckSettings WHERE DeckId = ? AND Key = ?", deckId, key).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return db.getSetting(key)
+	}
+	if err != nil {
+		log.Printf("Error occurred whilst reading setting %v of deck Id: %v - error: %v", key, deckId, err)
+		return db.getSetting(key)
+	}
+	return value
+}
+
+func (db *DB) getDeckFloatSetting(deckId int, key string) float64 {
+	value, err := strconv.ParseFloat(db.getDeckSetting(deckId, key), 64)
+	if err != nil {
+		value, _ = strconv.ParseFloat(defaultSettings[key], 64)
+	}
+	return value
+}
+
+func (db *DB) setSetting(key string, value string) error {
+	if err := validateSetting(key, value); err != nil {
+		return err
+	}
+	_, err := db.db.Exec("INSERT INTO Settings(Key, Value) VALUES (?, ?) ON CONFLICT(Key) DO UPDATE SET Value = excluded.Value", key, value)
+	return err
+}
+
+func (db *DB) setDeckSetting(deckId int, key string, value string) error {
+	if !slices.Contains(deckSettings, key) {
+		return fmt.Errorf("%s can't be set per deck", key)
+	}
+	if err := validateSetting(key, value); err != nil {
+		return err
+	}
+	_, err := db.db.Exec("INSERT INTO DeckSettings(DeckId, Key, Value) VALUES (?, ?, ?) ON CONFLICT(DeckId, Key) DO UPDATE SET Value = excluded.Value", deckId, key, value)
+	return err
+}
+
+func validateSetting(key string, value string) error {
+	if _, err := strconv.ParseBool(defaultSettings[key]); err == nil {
+		if _, err := strconv.ParseBool(value); err != nil {
+			return fmt.Errorf("%s expects true or false", key)
+		}
+	}
+	if _, err := strconv.Atoi(defaultSettings[key]); err == nil {
+		if n, err := strconv.Atoi(value); err != nil || n < 0 {
+			return fmt.Errorf("%s expects a whole number of 0 or more", key)
+		} else if bounds, ok := settingRanges[key]; ok && (n < bounds[0] || n > bounds[1]) {
+			return fmt.Errorf("%s expects a number from %d to %d", key, bounds[0], bounds[1])
+		}
+	}
+	if choices, ok := settingChoices[key]; ok && !slices.Contains(choices, value) {
+		return fmt.Errorf("%s expects one of: %s", key, strings.Join(choices, ", "))
+	}
+	if bounds, ok := settingFloatRanges[key]; ok {
+		if n, err := strconv.ParseFloat(value, 64); err != nil || n < bounds[0] || n > bounds[1] {
+			return fmt.Errorf("%s expects a number from %g to %g", key, bounds[0], bounds[1])
+		}
+	}
+	return nil
+}
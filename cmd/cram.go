@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+
+	"github.com/spf13/cobra"
+)
+
+// cramCmd represents the cram command
+var cramCmd = &cobra.Command{
+	Use:   "cram",
+	Short: "Drill a whole deck without changing its scheduling",
+	Long: `
+Drill every card of a deck regardless of when it is due. Failed cards are
+shown again at the end of the queue until they are answered correctly.
+Intervals, ease factors and due dates are never changed.
+	`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		deckName, _ := cmd.Flags().GetString("deck")
+		shuffle, _ := cmd.Flags().GetBool("shuffle")
+		limit, _ := cmd.Flags().GetInt("limit")
+
+		db := openDb()
+		deck, err := db.getDeckByName(deckName)
+		if err != nil {
+			log.Fatalf("Deck %q not found\n", deckName)
+		}
+
+		cards := getCardsFromDeck(db, deck.Id)
+		if shuffle {
+			rand.Shuffle(len(cards.Cards), func(i, j int) {
+				cards.Cards[i], cards.Cards[j] = cards.Cards[j], cards.Cards[i]
+			})
+		}
+		if limit > 0 && limit < len(cards.Cards) {
+			cards.Cards = cards.Cards[:limit]
+		}
+		if len(cards.Cards) == 0 {
+			fmt.Print("No cards in deck \n ")
+			return
+		}
+
+		score := cards.cram()
+		clearConsole()
+		score.print()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(cramCmd)
+
+	cramCmd.Flags().String("deck", "", "name of the deck to cram")
+	cramCmd.Flags().Bool("shuffle", false, "drill the cards in random order")
+	cramCmd.Flags().Int("limit", 0, "maximum number of cards to drill")
+	cramCmd.MarkFlagRequired("deck")
+}
+
+type cramScore struct {
+	Cards          int
+	Attempts       int
+	FirstTry       int
+	NeededRetrying []string
+}
+
+// cram shows every card until it is graded above 3 and scores the first
+// attempt at each card.
+func (d *ReviewDeck) cram() cramScore {
+	score := cramScore{Cards: len(d.Cards)}
+	seen := map[int]bool{}
+	for len(d.Cards) > 0 {
+		clearConsole()
+		card := d.Cards[0]
+		quality := parseInput(card.viewFrontAndBack(false))
+		passed := quality > 3
+
+		score.Attempts++
+		if !seen[card.Id] {
+			seen[card.Id] = true
+			if passed {
+				score.FirstTry++
+			} else {
+				score.NeededRetrying = append(score.NeededRetrying, card.Front)
+			}
+		}
+		d.updateReviewDeck(passed)
+	}
+	return score
+}
+
+func (s cramScore) print() {
+	fmt.Print("Cram complete! 🎉 \n ")
+	fmt.Printf("Cards: %d\n", s.Cards)
+	fmt.Printf("Correct on first try: %d (%.0f%%)\n", s.FirstTry, float64(s.FirstTry)/float64(s.Cards)*100)
+	fmt.Printf("Attempts: %d\n", s.Attempts)
+	if len(s.NeededRetrying) > 0 {
+		fmt.Println("Needed more than one try:")
+		for _, front := range s.NeededRetrying {
+			fmt.Printf("  - %s\n", front)
+		}
+	}
+}
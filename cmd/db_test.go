@@ -0,0 +1,106 @@
+package cmd
+
+import (
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// testZone is used as the local time zone of every test, so study days and
+// due dates don't depend on the machine running them.
+var testZone = time.FixedZone("UTC+2", 2*60*60)
+
+func TestMain(m *testing.M) {
+	time.Local = testZone
+	// Hooks, snapshots and caches are found relative to the working
+	// directory, which mustn't be the source tree.
+	dir, err := os.MkdirTemp("", "playita-test")
+	if err != nil {
+		panic(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		panic(err)
+	}
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// fixedClock is a clock that only moves when told to.
+type fixedClock struct {
+	t time.Time
+}
+
+func (c *fixedClock) Now() time.Time {
+	return c.t
+}
+
+// localTime returns a time in the test zone.
+func localTime(year int, month time.Month, day int, hour int, min int) time.Time {
+	return time.Date(year, month, day, hour, min, 0, 0, time.Local)
+}
+
+// newTestDb creates an empty collection in a temporary directory whose
+// clock is stopped at now and whose fuzz is drawn from a seeded source.
+func newTestDb(t *testing.T, now time.Time) (*DB, *fixedClock) {
+	t.Helper()
+	clock := &fixedClock{t: now}
+	db, err := newDb(filepath.Join(t.TempDir(), "playita"), clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.rand = rand.New(rand.NewSource(1))
+	t.Cleanup(func() { db.db.Close() })
+	return db, clock
+}
+
+func mustSetSetting(t *testing.T, db *DB, key string, value string) {
+	t.Helper()
+	if err := db.setSetting(key, value); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// addTestDeck creates a deck without going through addNewDeck, which
+// prints.
+func addTestDeck(t *testing.T, db *DB, name string) int {
+	t.Helper()
+	res, err := db.db.Exec("INSERT INTO Decks(Name) VALUES (?)", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := res.LastInsertId()
+	return int(id)
+}
+
+// addTestCard adds a Basic note to a deck and returns its card.
+func addTestCard(t *testing.T, db *DB, deckId int, front string, back string) *BaseCard {
+	t.Helper()
+	nt, err := db.getNoteTypeByName(NoteTypeBasic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	note := &Note{DeckId: deckId, NoteTypeId: nt.Id, Fields: map[string]string{"Front": front, "Back": back}}
+	if err := db.insertNote(note); err != nil {
+		t.Fatal(err)
+	}
+	cards, err := db.getNoteCards(note.Id)
+	if err != nil || len(cards) != 1 {
+		t.Fatalf("expected one card for note %d, got %d: %v", note.Id, len(cards), err)
+	}
+	return &cards[0]
+}
+
+// setSchedule overwrites the scheduling of a card.
+func setSchedule(t *testing.T, db *DB, card *BaseCard, repetition int, interval int, ease float32, due time.Time) {
+	t.Helper()
+	card.Repetition, card.Interval, card.EaseFactor, card.ReviewDate = repetition, interval, ease, due
+	_, err := db.db.Exec("UPDATE Cards SET Repetition = ?, Interval = ?, EaseFactor = ?, ReviewDate = ? WHERE Id = ?", repetition, interval, ease, due, card.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,114 @@
+package cmd
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/spf13/cobra"
+)
+
+// deckCmd represents the deck command
+var deckCmd = &cobra.Command{
+	Use:   "deck",
+	Short: "Manage decks without opening the menu",
+}
+
+var deckListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List decks with their number of cards and due cards",
+	Long: `
+List every deck with its number of cards, of cards due today and the
+average number of seconds taken to answer one of its cards.
+
+With --output json or jsonl each deck is written as:
+
+  {"schema_version": 1, "id": 1, "name": "Spanish", "cards": 120, "due": 14,
+   "seconds_per_card": 8.4}
+	`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		db := openDb()
+		records := db.getDeckRecords()
+		if len(records) == 0 && tableOutput() {
+			fmt.Print("No decks found 😔 \n ")
+			return
+		}
+		printRecords(records, deckOutputColumns)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(deckCmd)
+	deckCmd.AddCommand(deckListCmd)
+}
+
+type deckRecord struct {
+	schema
+	Id    int    `json:"id"`
+	Name  string `json:"name"`
+	Cards int    `json:"cards"`
+	Due   int    `json:"due"`
+	// SecondsPerCard is the average time taken to answer, 0 until a card
+	// of the deck has been answered.
+	SecondsPerCard float64 `json:"seconds_per_card"`
+}
+
+var deckOutputColumns = []outputColumn[deckRecord]{
+	{"id", func(d deckRecord) string { return strconv.Itoa(d.Id) }},
+	{"name", func(d deckRecord) string { return d.Name }},
+	{"cards", func(d deckRecord) string { return strconv.Itoa(d.Cards) }},
+	{"due", func(d deckRecord) string { return strconv.Itoa(d.Due) }},
+	{"seconds_per_card", func(d deckRecord) string { return strconv.FormatFloat(d.SecondsPerCard, 'f', 1, 64) }},
+}
+
+func (db *DB) getDeckRecords() []deckRecord {
+	records := []deckRecord{}
+	seconds := db.getAnswerSeconds()
+	for _, deck := range db.getDeckCounts() {
+		records = append(records, deckRecord{schema: currentSchema, Id: deck.Id, Name: deck.Name, Cards: deck.Cards, Due: deck.CardsToReview, SecondsPerCard: seconds[deck.Id]})
+	}
+	return records
+}
+
+// getAnswerSeconds returns the average number of seconds taken to answer a
+// card of each deck, leaving out reviews that weren't timed.
+func (db *DB) getAnswerSeconds() map[int]float64 {
+	seconds := map[int]float64{}
+	stmt := "SELECT c.DeckId, AVG(r.Duration) / 1000.0 FROM Reviews r JOIN Cards c ON c.Id = r.CardId WHERE r.Duration > 0 GROUP BY c.DeckId"
+	err := scanEach(db.db, stmt, func(rows *sql.Rows) error {
+		var deckId int
+		var average float64
+		if err := rows.Scan(&deckId, &average); err != nil {
+			return err
+		}
+		seconds[deckId] = average
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error occurred whilst averaging answer times: %v", err)
+	}
+	return seconds
+}
+
+// getDeckCounts returns every deck with its number of cards and of cards
+// due today, including decks with nothing due.
+func (db *DB) getDeckCounts() []BaseDeckWithCardCount {
+	stmt := "SELECT Decks.Id, Decks.Name, COUNT(Cards.Id), COUNT(CASE WHEN datetime(Cards.ReviewDate) < datetime(?) AND " + cardAvailable + " THEN 1 END) FROM Decks LEFT JOIN Cards ON Cards.DeckId = Decks.Id GROUP BY Decks.Id ORDER BY Decks.Name, Decks.Id;"
+	rows, err := db.db.Query(stmt, db.dueCutoff(0), db.now().UTC())
+	if err != nil {
+		log.Fatal("Error querying for decks", err)
+	}
+	defer rows.Close()
+
+	decks := []BaseDeckWithCardCount{}
+	for rows.Next() {
+		i := BaseDeckWithCardCount{}
+		if err := rows.Scan(&i.Id, &i.Name, &i.Cards, &i.CardsToReview); err != nil {
+			log.Printf("Error occurred whilst mapping decks Id: %v - error: %v", i.Id, err)
+		}
+		decks = append(decks, i)
+	}
+	return decks
+}
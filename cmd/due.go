@@ -0,0 +1,213 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"text/template"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// dueCacheFile holds the due counts of every deck, so prompts asking for
+// them many times a minute don't query the database each time.
+const dueCacheFile = dbFile + "-due.json"
+
+// dueCache is valid until the next study day starts or the database
+// changes, whichever comes first.
+type dueCache struct {
+	ValidUntil time.Time    `json:"valid_until"`
+	DbModTime  time.Time    `json:"db_mod_time"`
+	DbSize     int64        `json:"db_size"`
+	Decks      []deckRecord `json:"decks"`
+}
+
+// dueSummary is given to the --format template.
+type dueSummary struct {
+	// Total is the number of cards due in every deck, or in the deck
+	// selected with --deck.
+	Total int
+	Decks []deckRecord
+}
+
+// dueCmd represents the due command
+var dueCmd = &cobra.Command{
+	Use:   "due",
+	Short: "Print the number of cards due, for prompts and status bars",
+	Long: `
+Print the number of cards due today without opening the menu. The counts
+are cached until the database changes or the next study day starts, so
+calling due from a shell prompt or status bar costs a few milliseconds.
+
+--format is a Go template given .Total, the number of cards due, and
+.Decks, every deck with its .Name, .Cards and .Due. With --output json,
+jsonl or csv the decks are listed as by deck list instead.
+
+Nothing is printed and the exit status is 1 when there is no database, so
+a prompt shows nothing outside of the directory with the collection.
+Snippets for shell prompts are printed by due prompt bash, zsh or fish.
+	`,
+	Example: `  playita due
+  playita due --deck Spanish --format '{{.Total}}'
+  playita due --format '{{range .Decks}}{{.Name}}: {{.Due}} {{end}}'`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		deckName, _ := cmd.Flags().GetString("deck")
+		format, _ := cmd.Flags().GetString("format")
+		dir, _ := cmd.Flags().GetString("dir")
+
+		tmpl, err := template.New("format").Parse(format)
+		if err != nil {
+			log.Fatalf("Invalid --format: %v\n", err)
+		}
+		if dir != "" {
+			if err := os.Chdir(dir); err != nil {
+				os.Exit(1)
+			}
+		}
+		decks, err := dueCounts()
+		if os.IsNotExist(err) {
+			os.Exit(1)
+		} else if err != nil {
+			log.Fatal("Failed to count due cards ", err)
+		}
+
+		summary := dueSummary{Decks: decks}
+		found := deckName == ""
+		for _, deck := range decks {
+			if deckName == "" || deck.Name == deckName {
+				summary.Total += deck.Due
+				found = true
+			}
+		}
+		if !found {
+			log.Fatalf("Deck %q not found\n", deckName)
+		}
+		if !tableOutput() {
+			printRecords(decks, deckOutputColumns)
+			return
+		}
+		if err := tmpl.Execute(os.Stdout, summary); err != nil {
+			log.Fatalf("Invalid --format: %v\n", err)
+		}
+		fmt.Println()
+	},
+}
+
+var duePromptCmd = &cobra.Command{
+	Use:   "prompt bash|zsh|fish",
+	Short: "Print a snippet showing the due count in the shell prompt",
+	Long: `
+Print a snippet to add to ~/.bashrc, ~/.zshrc or ~/.config/fish/config.fish
+that puts the number of due cards in front of the prompt when there are
+any. The collection is looked for in $PLAYITA_DIR, or the home directory
+when it isn't set.
+	`,
+	Example:   `  playita due prompt bash >> ~/.bashrc`,
+	Args:      cobra.ExactArgs(1),
+	ValidArgs: []string{"bash", "zsh", "fish"},
+	Run: func(cmd *cobra.Command, args []string) {
+		snippet, ok := promptSnippets[args[0]]
+		if !ok {
+			log.Fatalf("Unknown shell %q, expected bash, zsh or fish\n", args[0])
+		}
+		fmt.Print(snippet)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(dueCmd)
+	dueCmd.AddCommand(duePromptCmd)
+
+	dueCmd.Flags().String("deck", "", "only count the cards of this deck")
+	dueCmd.Flags().String("format", "{{.Total}} due", "Go template of the output")
+	dueCmd.Flags().String("dir", "", "directory of the collection, instead of the current one")
+}
+
+const promptSnippetSh = `# playita: number of due cards in front of the prompt
+__playita_due() {
+	local due
+	due=$(playita due --dir "${PLAYITA_DIR:-$HOME}" --format '{{.Total}}' 2>/dev/null)
+	[ -n "$due" ] && [ "$due" != 0 ] && printf '%s due ' "$due"
+}
+`
+
+var promptSnippets = map[string]string{
+	"bash": promptSnippetSh + `PS1='$(__playita_due)'"$PS1"
+`,
+	"zsh": promptSnippetSh + `setopt PROMPT_SUBST
+PROMPT='$(__playita_due)'"$PROMPT"
+`,
+	"fish": `# playita: number of due cards in front of the prompt
+function __playita_due
+	set -l dir $HOME
+	set -q PLAYITA_DIR; and set dir $PLAYITA_DIR
+	set -l due (playita due --dir $dir --format '{{.Total}}' 2>/dev/null)
+	if test -n "$due"; and test "$due" != 0
+		printf '%s due ' $due
+	end
+end
+functions -q __playita_prompt; or functions -c fish_prompt __playita_prompt
+function fish_prompt
+	__playita_due
+	__playita_prompt
+end
+`,
+}
+
+// dueCounts returns every deck with its due count from the cache, counting
+// them again when it is out of date. The database isn't created when it
+// doesn't exist.
+func dueCounts() ([]deckRecord, error) {
+	info, err := os.Stat(dbFile + ".db")
+	if err != nil {
+		return nil, err
+	}
+	// Counts for a pretended date are neither read from nor kept in the
+	// cache.
+	if nowOverride == "" {
+		cache := dueCache{}
+		if data, err := os.ReadFile(dueCacheFile); err == nil && json.Unmarshal(data, &cache) == nil {
+			if time.Now().Before(cache.ValidUntil) && cache.DbModTime.Equal(info.ModTime()) && cache.DbSize == info.Size() {
+				return cache.Decks, nil
+			}
+		}
+	}
+
+	db, err := newDb(dbFile, clockFromFlags())
+	if err != nil {
+		return nil, err
+	}
+	defer db.db.Close()
+	decks := db.getDeckRecords()
+	if nowOverride != "" {
+		return decks, nil
+	}
+
+	// Opening the database may have migrated it, so it is looked at again
+	// to match what the next call sees.
+	if info, err = os.Stat(dbFile + ".db"); err != nil {
+		return nil, err
+	}
+	cache := dueCache{
+		ValidUntil: db.dayStart(db.today().AddDate(0, 0, 1)),
+		DbModTime:  info.ModTime(),
+		DbSize:     info.Size(),
+		Decks:      decks,
+	}
+	data, err := json.Marshal(cache)
+	if err != nil {
+		return nil, err
+	}
+	tmp := dueCacheFile + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		log.Printf("Failed to cache due counts: %v", err)
+		return decks, nil
+	}
+	if err := os.Rename(tmp, dueCacheFile); err != nil {
+		log.Printf("Failed to cache due counts: %v", err)
+	}
+	return decks, nil
+}
@@ -0,0 +1,131 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+
+	"golang.org/x/exp/slices"
+)
+
+// errEditCancelled is returned when the user saves an empty file.
+var errEditCancelled = errors.New("edit cancelled")
+
+var fieldHeaderPattern = regexp.MustCompile(`^=== (\w+) ===\s*$`)
+
+const noteFileHelp = `# Write each field below its "=== Name ===" line, multiple lines are allowed.
+# Lines starting with # above the first field are ignored.
+# Save an empty file to cancel.
+`
+
+// editorCommand returns the user's editor split into program and arguments,
+// so values such as "code --wait" work.
+func editorCommand() []string {
+	for _, env := range []string{"VISUAL", "EDITOR"} {
+		if editor := strings.Fields(os.Getenv(env)); len(editor) > 0 {
+			return editor
+		}
+	}
+	return []string{"vi"}
+}
+
+// editNoteFields opens the fields of a note in the user's editor and
+// returns them once they are saved and valid. Invalid input re-opens the
+// editor with the problem noted at the top of the file.
+func editNoteFields(nt *NoteType, fields map[string]string) (map[string]string, error) {
+	file, err := os.CreateTemp("", "playita-*.md")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(file.Name())
+
+	content := formatNoteFile(nt, fields)
+	for {
+		if err := os.WriteFile(file.Name(), []byte(content), 0o600); err != nil {
+			return nil, err
+		}
+
+		editor := editorCommand()
+		c := exec.Command(editor[0], append(editor[1:], file.Name())...)
+		c.Stdin = os.Stdin
+		c.Stdout = os.Stdout
+		c.Stderr = os.Stderr
+		if err := c.Run(); err != nil {
+			return nil, fmt.Errorf("running editor %s: %w", editor[0], err)
+		}
+
+		saved, err := os.ReadFile(file.Name())
+		if err != nil {
+			return nil, err
+		}
+		if strings.TrimSpace(string(saved)) == "" {
+			return nil, errEditCancelled
+		}
+
+		edited, err := parseNoteFile(nt, string(saved))
+		if err == nil {
+			return edited, nil
+		}
+		content = fmt.Sprintf("# ERROR: %v\n%s", err, stripErrorLines(string(saved)))
+	}
+}
+
+func formatNoteFile(nt *NoteType, fields map[string]string) string {
+	var b strings.Builder
+	b.WriteString(noteFileHelp)
+	for _, field := range nt.Fields {
+		fmt.Fprintf(&b, "=== %s ===\n", field)
+		if value := fields[field]; value != "" {
+			b.WriteString(value)
+			b.WriteString("\n")
+		}
+	}
+	return b.String()
+}
+
+// parseNoteFile reads back a file written by formatNoteFile. Blank lines
+// around each field are trimmed, the first field cannot be empty.
+func parseNoteFile(nt *NoteType, content string) (map[string]string, error) {
+	fields := map[string]string{}
+	current := ""
+	values := map[string][]string{}
+	for _, line := range strings.Split(strings.ReplaceAll(content, "\r\n", "\n"), "\n") {
+		if m := fieldHeaderPattern.FindStringSubmatch(line); m != nil {
+			if _, seen := values[m[1]]; seen {
+				return nil, fmt.Errorf("field %s appears twice", m[1])
+			}
+			current = m[1]
+			values[current] = []string{}
+			continue
+		}
+		if current == "" {
+			if strings.TrimSpace(line) != "" && !strings.HasPrefix(line, "#") {
+				return nil, errors.New(`text found before the first "=== Name ===" line`)
+			}
+			continue
+		}
+		values[current] = append(values[current], line)
+	}
+
+	for name, lines := range values {
+		if !slices.Contains(nt.Fields, name) {
+			return nil, fmt.Errorf("note type %s has no field %s", nt.Name, name)
+		}
+		fields[name] = strings.Trim(strings.Join(lines, "\n"), "\n")
+	}
+	if strings.TrimSpace(fields[nt.Fields[0]]) == "" {
+		return nil, fmt.Errorf("%s cannot be empty", strings.ToLower(nt.Fields[0]))
+	}
+	return fields, nil
+}
+
+func stripErrorLines(content string) string {
+	lines := strings.Split(content, "\n")
+	for len(lines) > 0 && strings.HasPrefix(lines[0], "# ERROR:") {
+		lines = lines[1:]
+	}
+	return strings.Join(lines, "\n")
+}
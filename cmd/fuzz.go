@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"time"
+)
+
+// minFuzzInterval is the shortest interval that is fuzzed; shorter ones
+// would move by a large share of themselves.
+const minFuzzInterval = 3
+
+// fuzzRange returns the shortest and longest interval interval can be
+// fuzzed to by percent.
+func fuzzRange(interval int, percent int) (int, int) {
+	if interval < minFuzzInterval || percent <= 0 {
+		return interval, interval
+	}
+	spread := max(int(math.Round(float64(interval*percent)/100)), 1)
+	return interval - spread, interval + spread
+}
+
+// fuzzInterval spreads cards that would be due on the same day. Without
+// the load-balance setting a random interval in the fuzz range is picked;
+// with it, the one whose due day has the fewest cards, closest to interval
+// on a tie. base is the day the interval counts from.
+func (db *DB) fuzzInterval(interval int, base time.Time) int {
+	lo, hi := fuzzRange(interval, db.getIntSetting("interval-fuzz"))
+	if lo == hi {
+		return interval
+	}
+	if !db.getBoolSetting("load-balance") {
+		return lo + db.rand.Intn(hi-lo+1)
+	}
+
+	loads := db.countDueByDay(base, lo, hi)
+	best, bestLoad := interval, -1
+	for candidate := lo; candidate <= hi; candidate++ {
+		load := loads[candidate]
+		closer := abs(candidate-interval) < abs(best-interval)
+		if bestLoad < 0 || load < bestLoad || load == bestLoad && closer {
+			best, bestLoad = candidate, load
+		}
+	}
+	return best
+}
+
+// countDueByDay returns the number of unsuspended cards due on each study
+// day from base+from to base+to, keyed by its offset from base. A card is
+// due on the day it first shows up in reviews, as dueCutoff decides, so
+// cards due at any time of day are counted.
+func (db *DB) countDueByDay(base time.Time, from int, to int) map[int]int {
+	day := "CASE"
+	args := []any{}
+	for offset := from; offset < to; offset++ {
+		day += fmt.Sprintf(" WHEN datetime(ReviewDate) < datetime(?) THEN %d", offset)
+		args = append(args, dueOn(base.AddDate(0, 0, offset+1)))
+	}
+	day += fmt.Sprintf(" ELSE %d END", to)
+	stmt := "SELECT " + day + " AS Day, COUNT(*) FROM Cards WHERE Suspended = 0 AND datetime(ReviewDate) >= datetime(?) AND datetime(ReviewDate) < datetime(?) GROUP BY Day"
+	args = append(args, dueOn(base.AddDate(0, 0, from)), dueOn(base.AddDate(0, 0, to+1)))
+
+	counts := map[int]int{}
+	rows, err := db.db.Query(stmt, args...)
+	if err != nil {
+		log.Printf("Error occurred whilst counting cards due from %v - error: %v", base.AddDate(0, 0, from).Format("2006-01-02"), err)
+		return counts
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var offset, count int
+		if err := rows.Scan(&offset, &count); err != nil {
+			log.Printf("Error occurred whilst counting cards due from %v - error: %v", base.AddDate(0, 0, from).Format("2006-01-02"), err)
+			return counts
+		}
+		counts[offset] = count
+	}
+	return counts
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFuzzRange(t *testing.T) {
+	tests := []struct {
+		interval int
+		percent  int
+		lo, hi   int
+	}{
+		{1, 5, 1, 1},
+		{2, 50, 2, 2},
+		{3, 5, 2, 4},
+		{10, 5, 9, 11},
+		{20, 25, 15, 25},
+		{100, 5, 95, 105},
+		{100, 0, 100, 100},
+	}
+	for _, tt := range tests {
+		if lo, hi := fuzzRange(tt.interval, tt.percent); lo != tt.lo || hi != tt.hi {
+			t.Errorf("fuzzRange(%d, %d) = %d, %d, want %d, %d", tt.interval, tt.percent, lo, hi, tt.lo, tt.hi)
+		}
+	}
+}
+
+func TestFuzzIntervalRandom(t *testing.T) {
+	db, _ := newTestDb(t, localTime(2024, 5, 10, 9, 0))
+	mustSetSetting(t, db, "interval-fuzz", "10")
+	mustSetSetting(t, db, "load-balance", "false")
+
+	seen := map[int]bool{}
+	for i := 0; i < 100; i++ {
+		got := db.fuzzInterval(20, db.today())
+		if got < 18 || got > 22 {
+			t.Fatalf("fuzzInterval(20) = %d, outside 18 to 22", got)
+		}
+		seen[got] = true
+	}
+	if len(seen) != 5 {
+		t.Errorf("picked %d of the 5 intervals in range", len(seen))
+	}
+}
+
+func TestFuzzIntervalLoadBalance(t *testing.T) {
+	today := localTime(2024, 5, 10, 0, 0)
+	at := func(day int, hour int) time.Time {
+		return localTime(2024, 5, 10+day, hour, 0).UTC()
+	}
+
+	tests := []struct {
+		name string
+		due  []time.Time
+		want int
+	}{
+		{"empty", nil, 10},
+		{"least loaded", []time.Time{at(9, 12), at(10, 12), at(10, 12)}, 11},
+		{"tie keeps the closest", []time.Time{at(9, 12), at(10, 12), at(11, 12)}, 10},
+		// 08:00 is before the cutoff of day 12, so it is due on day 11.
+		{"any time of day", []time.Time{at(9, 12), at(10, 12), at(11, 15), at(12, 8)}, 10},
+		{"before and after the range", []time.Time{at(8, 12), at(8, 18), at(12, 12), at(10, 12)}, 9},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, _ := newTestDb(t, localTime(2024, 5, 10, 9, 0))
+			mustSetSetting(t, db, "interval-fuzz", "10")
+			mustSetSetting(t, db, "load-balance", "true")
+			deckId := addTestDeck(t, db, "Deck")
+			for _, due := range tt.due {
+				setSchedule(t, db, addTestCard(t, db, deckId, "front", "back"), 2, 6, 2.5, due)
+			}
+			// Suspended cards don't count.
+			for i := 0; i < 3; i++ {
+				card := addTestCard(t, db, deckId, "suspended", "back")
+				setSchedule(t, db, card, 2, 6, 2.5, at(tt.want, 12))
+				db.setSuspended(card.Id, true)
+			}
+
+			if got := db.fuzzInterval(10, today); got != tt.want {
+				t.Errorf("fuzzInterval(10) = %d, want %d, loads %v", got, tt.want, db.countDueByDay(today, 9, 11))
+			}
+		})
+	}
+}
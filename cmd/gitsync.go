@@ -0,0 +1,996 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/exp/slices"
+)
+
+// gitDeckDir holds one file per deck in the repository, gitNoteTypesFile
+// the note types.
+const (
+	gitDeckDir       = "decks"
+	gitNoteTypesFile = "note-types.txt"
+)
+
+// gitRecordKeys lists the keys of each kind of record in the order they are
+// written. Notes and cards have a deck key that isn't written since it is
+// given by the file they are in. Keys in gitScheduleKeys are taken together
+// from the side whose card was reviewed last when both sides changed a card.
+var gitRecordKeys = map[string][]string{
+	"deck":     {"name"},
+	"notetype": {"name", "field", "template"},
+	"note":     {"deck", "type", "tags", "field"},
+	"card":     {"deck", "note", "ord", "front", "back", "suspended", "flag", "interval", "ease", "repetition", "due", "buried", "lapses", "review"},
+}
+
+var gitScheduleKeys = []string{"interval", "ease", "repetition", "due", "buried", "lapses"}
+
+// gitKinds are the kinds of record in the order they are applied to the
+// database, parents first.
+var gitKinds = []string{"notetype", "deck", "note", "card"}
+
+// gitSyncCmd represents the git-sync command
+var gitSyncCmd = &cobra.Command{
+	Use:   "git-sync <repo-dir>",
+	Short: "Sync the collection through a git repository",
+	Long: `
+Write every deck as a text file to a git repository, commit it, merge the
+changes of its upstream branch and load the result back, then push.
+
+Changes are merged card by card, not line by line: when both sides changed
+a card the scheduling of the side that reviewed it last is kept along with
+the reviews of both sides, and other fields changed on one side only are
+taken from that side. When both sides changed the same field differently
+the local version is kept.
+
+The repository is created with git init or git clone beforehand; without
+an upstream branch the changes are only committed.
+	`,
+	Example: `  git clone git@example.com:me/cards.git ~/cards
+  playita git-sync ~/cards`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		db := openDb()
+		repo, err := openGitRepo(args[0])
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := db.gitSync(repo); err != nil {
+			log.Fatal("Git sync failed ", err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(gitSyncCmd)
+}
+
+// gitRecord is a deck, note type, note or card as written to the
+// repository: a kind and a Guid followed by lines of keys and values.
+type gitRecord struct {
+	Kind   string
+	Guid   string
+	Values map[string][]string
+}
+
+func newGitRecord(kind string, guid string) *gitRecord {
+	return &gitRecord{Kind: kind, Guid: guid, Values: map[string][]string{}}
+}
+
+func (r *gitRecord) get(key string) string {
+	if values := r.Values[key]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func (r *gitRecord) set(key string, values ...string) {
+	r.Values[key] = values
+}
+
+// format writes the record, without its deck when it is implied by the
+// file.
+func (r *gitRecord) format(withDeck bool) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s\n", r.Kind, r.Guid)
+	for _, key := range gitRecordKeys[r.Kind] {
+		if key == "deck" && !withDeck {
+			continue
+		}
+		for _, value := range r.Values[key] {
+			fmt.Fprintf(&b, "%s %s\n", key, value)
+		}
+	}
+	return b.String()
+}
+
+func (r *gitRecord) equal(other *gitRecord) bool {
+	if r == nil || other == nil {
+		return r == other
+	}
+	return r.format(true) == other.format(true)
+}
+
+// gitCollection holds records by kind and Guid.
+type gitCollection map[string]map[string]*gitRecord
+
+func newGitCollection() gitCollection {
+	c := gitCollection{}
+	for _, kind := range gitKinds {
+		c[kind] = map[string]*gitRecord{}
+	}
+	return c
+}
+
+func (c gitCollection) add(r *gitRecord) {
+	c[r.Kind][r.Guid] = r
+}
+
+func sortedGuids(records map[string]*gitRecord) []string {
+	guids := make([]string, 0, len(records))
+	for guid := range records {
+		guids = append(guids, guid)
+	}
+	sort.Strings(guids)
+	return guids
+}
+
+// quoteAll quotes values so they fit on one line.
+func quoteAll(values ...string) string {
+	quoted := make([]string, len(values))
+	for i, value := range values {
+		quoted[i] = strconv.Quote(value)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// unquoteAll reads the values written by quoteAll.
+func unquoteAll(line string) ([]string, error) {
+	values := []string{}
+	for line = strings.TrimSpace(line); line != ""; line = strings.TrimSpace(line) {
+		quoted, err := strconv.QuotedPrefix(line)
+		if err != nil {
+			return nil, fmt.Errorf("invalid quoted value %s", line)
+		}
+		value, _ := strconv.Unquote(quoted)
+		values = append(values, value)
+		line = line[len(quoted):]
+	}
+	return values, nil
+}
+
+func unquote(line string) (string, error) {
+	values, err := unquoteAll(line)
+	if err != nil {
+		return "", err
+	}
+	if len(values) != 1 {
+		return "", fmt.Errorf("expected one quoted value, got %s", line)
+	}
+	return values[0], nil
+}
+
+func formatFloat(f float32) string {
+	return strconv.FormatFloat(float64(f), 'f', -1, 32)
+}
+
+// exportGit reads the collection as records.
+func (db *DB) exportGit() (gitCollection, error) {
+	c := newGitCollection()
+	err := scanEach(db.db, "SELECT Guid, Name FROM Decks", func(rows *sql.Rows) error {
+		var guid, name string
+		if err := rows.Scan(&guid, &name); err != nil {
+			return err
+		}
+		r := newGitRecord("deck", guid)
+		r.set("name", strconv.Quote(name))
+		c.add(r)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	noteTypes, err := db.getNoteTypes()
+	if err != nil {
+		return nil, err
+	}
+	for _, nt := range noteTypes {
+		var guid string
+		if err := db.db.QueryRow("SELECT Guid FROM NoteTypes WHERE Id = ?", nt.Id).Scan(&guid); err != nil {
+			return nil, err
+		}
+		r := newGitRecord("notetype", guid)
+		r.set("name", strconv.Quote(nt.Name))
+		for _, field := range nt.Fields {
+			r.Values["field"] = append(r.Values["field"], strconv.Quote(field))
+		}
+		for _, t := range nt.Templates {
+			r.Values["template"] = append(r.Values["template"], quoteAll(t.Name, t.Front, t.Back))
+		}
+		c.add(r)
+	}
+
+	stmt := "SELECT n.Guid, d.Guid, nt.Guid, n.Fields, n.Tags FROM Notes n JOIN Decks d ON d.Id = n.DeckId JOIN NoteTypes nt ON nt.Id = n.NoteTypeId"
+	err = scanEach(db.db, stmt, func(rows *sql.Rows) error {
+		var guid, deck, noteType, fields, tags string
+		if err := rows.Scan(&guid, &deck, &noteType, &fields, &tags); err != nil {
+			return err
+		}
+		values := map[string]string{}
+		if err := json.Unmarshal([]byte(fields), &values); err != nil {
+			return err
+		}
+		r := newGitRecord("note", guid)
+		r.set("deck", deck)
+		r.set("type", noteType)
+		r.set("tags", strconv.Quote(tags))
+		names := make([]string, 0, len(values))
+		for name := range values {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			r.Values["field"] = append(r.Values["field"], quoteAll(name, values[name]))
+		}
+		c.add(r)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	stmt = "SELECT c.Guid, d.Guid, COALESCE(n.Guid, ''), c.Ord, c.Front, c.Back, c.Suspended, c.Flag, c.Interval, c.EaseFactor, c.Repetition, c.ReviewDate, c.BuriedUntil, c.Lapses FROM Cards c JOIN Decks d ON d.Id = c.DeckId LEFT JOIN Notes n ON n.Id = c.NoteId"
+	err = scanEach(db.db, stmt, func(rows *sql.Rows) error {
+		var guid, deck, note, front, back string
+		var ord, flag, interval, repetition, lapses int
+		var suspended bool
+		var ease float32
+		var due time.Time
+		var buried sql.NullTime
+		if err := rows.Scan(&guid, &deck, &note, &ord, &front, &back, &suspended, &flag, &interval, &ease, &repetition, &due, &buried, &lapses); err != nil {
+			return err
+		}
+		r := newGitRecord("card", guid)
+		r.set("deck", deck)
+		r.set("note", note)
+		r.set("ord", strconv.Itoa(ord))
+		r.set("front", strconv.Quote(front))
+		r.set("back", strconv.Quote(back))
+		r.set("suspended", strconv.FormatBool(suspended))
+		r.set("flag", strconv.Itoa(flag))
+		r.set("interval", strconv.Itoa(interval))
+		r.set("ease", formatFloat(ease))
+		r.set("repetition", strconv.Itoa(repetition))
+		r.set("due", due.UTC().Format(time.RFC3339))
+		if buried.Valid {
+			r.set("buried", buried.Time.UTC().Format(time.RFC3339))
+		}
+		r.set("lapses", strconv.Itoa(lapses))
+		c.add(r)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Reviews are written oldest first with the card they belong to, ending
+	// with the answer time in milliseconds when it was timed.
+	stmt = "SELECT c.Guid, r.Guid, r.ReviewedAt, r.Grade, r.Interval, r.EaseFactor, r.Duration FROM Reviews r JOIN Cards c ON c.Id = r.CardId ORDER BY r.ReviewedAt, r.Guid"
+	err = scanEach(db.db, stmt, func(rows *sql.Rows) error {
+		var card, guid string
+		var reviewedAt time.Time
+		var grade, interval int
+		var ease float32
+		var duration int64
+		if err := rows.Scan(&card, &guid, &reviewedAt, &grade, &interval, &ease, &duration); err != nil {
+			return err
+		}
+		if r, ok := c["card"][card]; ok {
+			review := fmt.Sprintf("%s %s %d %d %s", guid, reviewedAt.UTC().Format(time.RFC3339Nano), grade, interval, formatFloat(ease))
+			if duration > 0 {
+				review += " " + strconv.FormatInt(duration, 10)
+			}
+			r.Values["review"] = append(r.Values["review"], review)
+		}
+		return nil
+	})
+	return c, err
+}
+
+// gitFiles lays the collection out as files: one per deck, named after it,
+// and one with the note types. Notes are written with their cards after
+// them.
+func (c gitCollection) gitFiles() map[string]string {
+	files := map[string]string{}
+
+	var b strings.Builder
+	b.WriteString("# playita note types\n")
+	for _, guid := range sortedGuids(c["notetype"]) {
+		b.WriteString("\n" + c["notetype"][guid].format(false))
+	}
+	files[gitNoteTypesFile] = b.String()
+
+	notes := map[string][]*gitRecord{}
+	for _, guid := range sortedGuids(c["note"]) {
+		note := c["note"][guid]
+		notes[note.get("deck")] = append(notes[note.get("deck")], note)
+	}
+	cards := map[string][]*gitRecord{}
+	for _, guid := range sortedGuids(c["card"]) {
+		card := c["card"][guid]
+		cards[card.get("deck")] = append(cards[card.get("deck")], card)
+	}
+
+	used := map[string]bool{}
+	for _, guid := range sortedGuids(c["deck"]) {
+		deck := c["deck"][guid]
+		name, _ := unquote(deck.get("name"))
+		file := gitDeckFileName(name)
+		if used[file] {
+			file = gitDeckFileName(name + "-" + guid[:min(8, len(guid))])
+		}
+		used[file] = true
+
+		var b strings.Builder
+		b.WriteString("# playita deck\n\n" + deck.format(false))
+		written := map[string]bool{}
+		deckCards := cards[guid]
+		sort.SliceStable(deckCards, func(i, j int) bool {
+			a, _ := strconv.Atoi(deckCards[i].get("ord"))
+			b, _ := strconv.Atoi(deckCards[j].get("ord"))
+			return a < b
+		})
+		for _, note := range notes[guid] {
+			b.WriteString("\n" + note.format(false))
+			for _, card := range deckCards {
+				if card.get("note") == note.Guid {
+					b.WriteString("\n" + card.format(false))
+					written[card.Guid] = true
+				}
+			}
+		}
+		for _, card := range cards[guid] {
+			if !written[card.Guid] {
+				b.WriteString("\n" + card.format(false))
+			}
+		}
+		files[filepath.ToSlash(filepath.Join(gitDeckDir, file))] = b.String()
+	}
+	return files
+}
+
+var gitFileNameUnsafe = regexp.MustCompile(`[^a-z0-9]+`)
+
+func gitDeckFileName(name string) string {
+	slug := strings.Trim(gitFileNameUnsafe.ReplaceAllString(strings.ToLower(name), "-"), "-")
+	if slug == "" {
+		slug = "deck"
+	}
+	return slug + ".txt"
+}
+
+// parseGitFile adds the records of a file to the collection.
+func (c gitCollection) parseGitFile(name string, data []byte) error {
+	var record *gitRecord
+	deck := ""
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if strings.HasPrefix(text, "#") {
+			continue
+		}
+		if strings.TrimSpace(text) == "" {
+			record = nil
+			continue
+		}
+		key, value, _ := strings.Cut(text, " ")
+		if record == nil {
+			if _, ok := gitRecordKeys[key]; !ok || value == "" {
+				return fmt.Errorf("%s:%d: expected a record, got %q", name, line, text)
+			}
+			record = newGitRecord(key, value)
+			switch key {
+			case "deck":
+				deck = value
+			case "note", "card":
+				if deck == "" {
+					return fmt.Errorf("%s:%d: %s outside of a deck", name, line, key)
+				}
+				record.set("deck", deck)
+			}
+			c.add(record)
+			continue
+		}
+		if key == "deck" || !slices.Contains(gitRecordKeys[record.Kind], key) {
+			return fmt.Errorf("%s:%d: unknown key %q for %s", name, line, key, record.Kind)
+		}
+		record.Values[key] = append(record.Values[key], value)
+	}
+	return scanner.Err()
+}
+
+// mergeGit merges two collections card by card with base as their common
+// ancestor, returning the number of fields changed differently on both
+// sides, for which ours was kept.
+func mergeGit(base gitCollection, ours gitCollection, theirs gitCollection) (gitCollection, int) {
+	merged := newGitCollection()
+	conflicts := 0
+	for _, kind := range gitKinds {
+		guids := map[string]*gitRecord{}
+		for _, c := range []gitCollection{base, ours, theirs} {
+			for guid, r := range c[kind] {
+				guids[guid] = r
+			}
+		}
+		for _, guid := range sortedGuids(guids) {
+			b, o, t := base[kind][guid], ours[kind][guid], theirs[kind][guid]
+			var r *gitRecord
+			switch {
+			case o.equal(t), t.equal(b):
+				r = o
+			case o.equal(b):
+				r = t
+			// A record changed on one side is kept even if the other
+			// deleted it.
+			case o == nil:
+				r = t
+			case t == nil:
+				r = o
+			default:
+				var n int
+				r, n = mergeGitRecord(b, o, t)
+				conflicts += n
+			}
+			if r != nil {
+				merged.add(r)
+			}
+		}
+	}
+
+	// Records that were deleted on one side but are still referenced by
+	// the other are brought back.
+	restore := func(kind string, guid string) {
+		if guid == "" || merged[kind][guid] != nil {
+			return
+		}
+		for _, c := range []gitCollection{ours, theirs, base} {
+			if r := c[kind][guid]; r != nil {
+				merged.add(r)
+				return
+			}
+		}
+	}
+	for _, card := range merged["card"] {
+		restore("note", card.get("note"))
+		restore("deck", card.get("deck"))
+	}
+	for _, note := range merged["note"] {
+		restore("deck", note.get("deck"))
+		restore("notetype", note.get("type"))
+	}
+	return merged, conflicts
+}
+
+// mergeGitRecord merges a record changed on both sides key by key.
+func mergeGitRecord(base *gitRecord, ours *gitRecord, theirs *gitRecord) (*gitRecord, int) {
+	if base == nil {
+		base = newGitRecord(ours.Kind, ours.Guid)
+	}
+	merged := newGitRecord(ours.Kind, ours.Guid)
+	conflicts := 0
+	schedule := ours
+	if ours.Kind == "card" && lastGitReview(theirs) > lastGitReview(ours) {
+		schedule = theirs
+	}
+	for _, key := range gitRecordKeys[ours.Kind] {
+		b, o, t := base.Values[key], ours.Values[key], theirs.Values[key]
+		switch {
+		case ours.Kind == "card" && slices.Contains(gitScheduleKeys, key):
+			merged.Values[key] = schedule.Values[key]
+		case key == "review":
+			merged.Values[key] = mergeGitReviews(o, t)
+		case slices.Equal(o, t), slices.Equal(t, b):
+			merged.Values[key] = o
+		case slices.Equal(o, b):
+			merged.Values[key] = t
+		default:
+			merged.Values[key] = o
+			conflicts++
+		}
+		if len(merged.Values[key]) == 0 {
+			delete(merged.Values, key)
+		}
+	}
+	return merged, conflicts
+}
+
+// lastGitReview returns the time of the last review of a card, which sorts
+// as text since reviews are written oldest first in UTC.
+func lastGitReview(card *gitRecord) string {
+	reviews := card.Values["review"]
+	if len(reviews) == 0 {
+		return ""
+	}
+	_, after, _ := strings.Cut(reviews[len(reviews)-1], " ")
+	at, _, _ := strings.Cut(after, " ")
+	return at
+}
+
+// mergeGitReviews keeps the reviews of both sides, oldest first.
+func mergeGitReviews(ours []string, theirs []string) []string {
+	seen := map[string]bool{}
+	reviews := []string{}
+	for _, review := range append(slices.Clone(ours), theirs...) {
+		guid, _, _ := strings.Cut(review, " ")
+		if !seen[guid] {
+			seen[guid] = true
+			reviews = append(reviews, review)
+		}
+	}
+	sort.SliceStable(reviews, func(i, j int) bool {
+		_, a, _ := strings.Cut(reviews[i], " ")
+		_, b, _ := strings.Cut(reviews[j], " ")
+		return a < b
+	})
+	return reviews
+}
+
+// importGit changes the database from the collection current, as exported
+// from it, to merged.
+func (db *DB) importGit(current gitCollection, merged gitCollection) error {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	idOf := func(table string, guid string) (int, error) {
+		var id int
+		err := tx.QueryRow("SELECT Id FROM "+table+" WHERE Guid = ?", guid).Scan(&id)
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, fmt.Errorf("%s %s not found", table, guid)
+		}
+		return id, err
+	}
+	// upsert updates the row with the Guid of r or inserts it.
+	upsert := func(table string, r *gitRecord, columns []string, values ...any) (int, error) {
+		id, err := idOf(table, r.Guid)
+		if err == nil {
+			_, err = tx.Exec("UPDATE "+table+" SET "+strings.Join(columns, " = ?, ")+" = ? WHERE Id = ?", append(values, id)...)
+			return id, err
+		}
+		placeholders := strings.Repeat("?, ", len(columns)) + "?"
+		res, err := tx.Exec("INSERT INTO "+table+"("+strings.Join(columns, ", ")+", Guid) VALUES ("+placeholders+")", append(values, r.Guid)...)
+		if err != nil {
+			return 0, err
+		}
+		newId, err := res.LastInsertId()
+		return int(newId), err
+	}
+
+	for _, kind := range gitKinds {
+		for _, guid := range sortedGuids(merged[kind]) {
+			r := merged[kind][guid]
+			if r.equal(current[kind][guid]) {
+				continue
+			}
+			if err := applyGitRecord(tx, r, current[kind][guid], idOf, upsert); err != nil {
+				return fmt.Errorf("%s %s: %w", kind, guid, err)
+			}
+		}
+	}
+
+	deletes := map[string]string{"card": "Cards", "note": "Notes", "deck": "Decks"}
+	for _, kind := range []string{"card", "note", "deck"} {
+		for guid := range current[kind] {
+			if merged[kind][guid] != nil {
+				continue
+			}
+			id, err := idOf(deletes[kind], guid)
+			if err != nil {
+				return err
+			}
+			if kind == "deck" {
+				if _, err := tx.Exec("DELETE FROM DeckSettings WHERE DeckId = ?", id); err != nil {
+					return err
+				}
+			}
+			if _, err := tx.Exec("DELETE FROM "+deletes[kind]+" WHERE Id = ?", id); err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+func applyGitRecord(tx *sql.Tx, r *gitRecord, current *gitRecord, idOf func(string, string) (int, error), upsert func(string, *gitRecord, []string, ...any) (int, error)) error {
+	switch r.Kind {
+	case "deck":
+		name, err := unquote(r.get("name"))
+		if err != nil {
+			return err
+		}
+		_, err = upsert("Decks", r, []string{"Name"}, name)
+		return err
+	case "notetype":
+		name, err := unquote(r.get("name"))
+		if err != nil {
+			return err
+		}
+		if name, err = freeNoteTypeName(tx, name, r.Guid); err != nil {
+			return err
+		}
+		fields := []string{}
+		for _, field := range r.Values["field"] {
+			value, err := unquote(field)
+			if err != nil {
+				return err
+			}
+			fields = append(fields, value)
+		}
+		encoded, err := json.Marshal(fields)
+		if err != nil {
+			return err
+		}
+		id, err := upsert("NoteTypes", r, []string{"Name", "Fields"}, name, string(encoded))
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec("DELETE FROM CardTemplates WHERE NoteTypeId = ?", id); err != nil {
+			return err
+		}
+		for ord, template := range r.Values["template"] {
+			values, err := unquoteAll(template)
+			if err != nil {
+				return err
+			}
+			if len(values) != 3 {
+				return fmt.Errorf("template needs a name, front and back, got %s", template)
+			}
+			stmt := "INSERT INTO CardTemplates(NoteTypeId, Ord, Name, Front, Back) VALUES (?, ?, ?, ?, ?)"
+			if _, err := tx.Exec(stmt, id, ord, values[0], values[1], values[2]); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "note":
+		deckId, err := idOf("Decks", r.get("deck"))
+		if err != nil {
+			return err
+		}
+		noteTypeId, err := idOf("NoteTypes", r.get("type"))
+		if err != nil {
+			return err
+		}
+		tags, err := unquote(r.get("tags"))
+		if err != nil {
+			return err
+		}
+		fields := map[string]string{}
+		for _, field := range r.Values["field"] {
+			values, err := unquoteAll(field)
+			if err != nil {
+				return err
+			}
+			if len(values) != 2 {
+				return fmt.Errorf("field needs a name and a value, got %s", field)
+			}
+			fields[values[0]] = values[1]
+		}
+		encoded, err := json.Marshal(fields)
+		if err != nil {
+			return err
+		}
+		_, err = upsert("Notes", r, []string{"DeckId", "NoteTypeId", "Fields", "Tags"}, deckId, noteTypeId, string(encoded), tags)
+		return err
+	default:
+		return applyGitCard(tx, r, current, idOf, upsert)
+	}
+}
+
+func applyGitCard(tx *sql.Tx, r *gitRecord, current *gitRecord, idOf func(string, string) (int, error), upsert func(string, *gitRecord, []string, ...any) (int, error)) error {
+	deckId, err := idOf("Decks", r.get("deck"))
+	if err != nil {
+		return err
+	}
+	noteId := 0
+	if r.get("note") != "" {
+		if noteId, err = idOf("Notes", r.get("note")); err != nil {
+			return err
+		}
+	}
+	front, err := unquote(r.get("front"))
+	if err != nil {
+		return err
+	}
+	back, err := unquote(r.get("back"))
+	if err != nil {
+		return err
+	}
+	ints := map[string]int{}
+	for _, key := range []string{"ord", "flag", "interval", "repetition", "lapses"} {
+		if ints[key], err = strconv.Atoi(r.get(key)); err != nil {
+			return fmt.Errorf("invalid %s %q", key, r.get(key))
+		}
+	}
+	suspended, err := strconv.ParseBool(r.get("suspended"))
+	if err != nil {
+		return fmt.Errorf("invalid suspended %q", r.get("suspended"))
+	}
+	ease, err := strconv.ParseFloat(r.get("ease"), 32)
+	if err != nil {
+		return fmt.Errorf("invalid ease %q", r.get("ease"))
+	}
+	due, err := time.Parse(time.RFC3339, r.get("due"))
+	if err != nil {
+		return fmt.Errorf("invalid due %q", r.get("due"))
+	}
+	var buried any
+	if r.get("buried") != "" {
+		t, err := time.Parse(time.RFC3339, r.get("buried"))
+		if err != nil {
+			return fmt.Errorf("invalid buried %q", r.get("buried"))
+		}
+		buried = t
+	}
+
+	columns := []string{"DeckId", "NoteId", "Ord", "Front", "Back", "Suspended", "Flag", "Interval", "EaseFactor", "Repetition", "ReviewDate", "BuriedUntil", "Lapses"}
+	id, err := upsert("Cards", r, columns, deckId, noteId, ints["ord"], front, back, suspended, ints["flag"], ints["interval"], ease, ints["repetition"], due, buried, ints["lapses"])
+	if err != nil {
+		return err
+	}
+
+	known := map[string]bool{}
+	if current != nil {
+		for _, review := range current.Values["review"] {
+			guid, _, _ := strings.Cut(review, " ")
+			known[guid] = true
+		}
+	}
+	for _, review := range r.Values["review"] {
+		parts := strings.Fields(review)
+		if len(parts) != 5 && len(parts) != 6 {
+			return fmt.Errorf("invalid review %q", review)
+		}
+		duration := "0"
+		if len(parts) == 6 {
+			duration = parts[5]
+		}
+		if known[parts[0]] {
+			continue
+		}
+		reviewedAt, err := time.Parse(time.RFC3339Nano, parts[1])
+		if err != nil {
+			return fmt.Errorf("invalid review %q", review)
+		}
+		stmt := "INSERT INTO Reviews(CardId, ReviewedAt, Grade, Interval, EaseFactor, Duration, Guid) SELECT ?, ?, ?, ?, ?, ?, ? WHERE NOT EXISTS (SELECT 1 FROM Reviews WHERE Guid = ?)"
+		if _, err := tx.Exec(stmt, id, reviewedAt, parts[2], parts[3], parts[4], duration, parts[0], parts[0]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// gitRepo runs git in the top directory of a repository.
+type gitRepo struct {
+	dir string
+}
+
+func openGitRepo(dir string) (gitRepo, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return gitRepo{}, err
+	}
+	repo := gitRepo{dir: abs}
+	top, err := repo.git("rev-parse", "--show-toplevel")
+	if err == nil {
+		var a, b string
+		a, err = filepath.EvalSymlinks(abs)
+		if err == nil {
+			b, err = filepath.EvalSymlinks(top)
+		}
+		if err == nil && a != b {
+			err = errors.New("not the top directory")
+		}
+	}
+	if err != nil {
+		return repo, fmt.Errorf("%s is not a git repository, create it with git init or git clone first", dir)
+	}
+	return repo, nil
+}
+
+func (r gitRepo) git(args ...string) (string, error) {
+	cmd := exec.Command("git", append([]string{"-C", r.dir}, args...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// writeFiles replaces the deck files and note types in the working tree.
+func (r gitRepo) writeFiles(files map[string]string) error {
+	stale, err := filepath.Glob(filepath.Join(r.dir, gitDeckDir, "*.txt"))
+	if err != nil {
+		return err
+	}
+	for _, path := range stale {
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(filepath.Join(r.dir, gitDeckDir), 0o755); err != nil {
+		return err
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(r.dir, filepath.FromSlash(name)), []byte(content), 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// commit stages the collection and commits it if anything changed.
+func (r gitRepo) commit(message string) (bool, error) {
+	if _, err := r.git("add", "-A", "--", gitDeckDir, gitNoteTypesFile); err != nil {
+		return false, err
+	}
+	if _, err := r.git("diff", "--cached", "--quiet"); err == nil {
+		return false, nil
+	}
+	_, err := r.git("commit", "-q", "-m", message)
+	return err == nil, err
+}
+
+// readCollection parses the collection as of a revision.
+func (r gitRepo) readCollection(rev string) (gitCollection, error) {
+	c := newGitCollection()
+	list, err := r.git("ls-tree", "-r", "--name-only", rev, "--", gitDeckDir, gitNoteTypesFile)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range strings.Fields(list) {
+		if name != gitNoteTypesFile && (path.Dir(name) != gitDeckDir || !strings.HasSuffix(name, ".txt")) {
+			continue
+		}
+		data, err := r.git("show", rev+":"+name)
+		if err != nil {
+			return nil, err
+		}
+		if err := c.parseGitFile(name, []byte(data)); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// commitMerge records a merge of upstream whose files are those of merged,
+// aborting it when any step fails.
+func (r gitRepo) commitMerge(upstream string, merged gitCollection) error {
+	if _, err := r.git("merge", "--quiet", "--no-ff", "--no-commit", "--allow-unrelated-histories", "-s", "ours", upstream); err != nil {
+		r.git("merge", "--abort")
+		return err
+	}
+	err := r.writeFiles(merged.gitFiles())
+	if err == nil {
+		_, err = r.git("add", "-A", "--", gitDeckDir, gitNoteTypesFile)
+	}
+	if err == nil {
+		_, err = r.git("commit", "-q", "-m", "Merge collection from "+upstream)
+	}
+	if err != nil {
+		r.git("merge", "--abort")
+	}
+	return err
+}
+
+func (r gitRepo) isAncestor(a string, b string) bool {
+	_, err := r.git("merge-base", "--is-ancestor", a, b)
+	return err == nil
+}
+
+// gitSync commits the collection, merges the upstream branch into it, loads
+// the result and pushes it. The result is loaded before HEAD moves: were it
+// the other way round, a failed load would leave upstream records in HEAD
+// that the database lacks, and the next run would commit their deletion.
+func (db *DB) gitSync(repo gitRepo) error {
+	current, err := db.exportGit()
+	if err != nil {
+		return err
+	}
+	if err := repo.writeFiles(current.gitFiles()); err != nil {
+		return err
+	}
+	committed, err := repo.commit("Update collection")
+	if err != nil {
+		return err
+	}
+	if committed {
+		fmt.Println("Committed local changes")
+	}
+
+	upstream, err := repo.git("rev-parse", "--abbrev-ref", "--symbolic-full-name", "@{u}")
+	if err != nil {
+		fmt.Println("No upstream branch, changes were only committed; set one with git push -u")
+		return nil
+	}
+	if _, err := repo.git("fetch", "--quiet"); err != nil {
+		return err
+	}
+
+	switch {
+	case repo.isAncestor(upstream, "HEAD"):
+		fmt.Printf("Nothing new on %s\n", upstream)
+	case repo.isAncestor("HEAD", upstream):
+		merged, err := repo.readCollection(upstream)
+		if err != nil {
+			return err
+		}
+		if err := db.importGit(current, merged); err != nil {
+			return err
+		}
+		if _, err := repo.git("merge", "--quiet", "--ff-only", upstream); err != nil {
+			return err
+		}
+		fmt.Printf("Loaded the changes from %s\n", upstream)
+	default:
+		// Histories started on two devices have no common base, everything
+		// in them was added on one side or the other.
+		base := newGitCollection()
+		if rev, err := repo.git("merge-base", "HEAD", upstream); err == nil {
+			if base, err = repo.readCollection(rev); err != nil {
+				return err
+			}
+		}
+		theirs, err := repo.readCollection(upstream)
+		if err != nil {
+			return err
+		}
+		merged, conflicts := mergeGit(base, current, theirs)
+		if err := db.importGit(current, merged); err != nil {
+			return err
+		}
+
+		// The merge is recorded with both parents but the tree is ours,
+		// which the merged files then replace.
+		if err := repo.commitMerge(upstream, merged); err != nil {
+			return err
+		}
+		fmt.Printf("Merged the changes from %s", upstream)
+		if conflicts > 0 {
+			fmt.Printf(", keeping the local version of %d fields changed on both sides", conflicts)
+		}
+		fmt.Println()
+	}
+
+	if ahead, err := repo.git("rev-list", "--count", upstream+"..HEAD"); err != nil {
+		return err
+	} else if ahead != "0" {
+		if _, err := repo.git("push", "--quiet"); err != nil {
+			return err
+		}
+		fmt.Printf("Pushed to %s\n", upstream)
+	}
+	return nil
+}
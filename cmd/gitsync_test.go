@@ -0,0 +1,260 @@
+package cmd
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/exp/slices"
+)
+
+// gitCard builds a card record; reviews are "<guid> <time>" pairs written
+// as full review lines.
+func gitCard(guid string, front string, interval string, reviews ...string) *gitRecord {
+	r := newGitRecord("card", guid)
+	r.set("deck", "d1")
+	r.set("note", "n1")
+	r.set("ord", "0")
+	r.set("front", quoteAll(front))
+	r.set("back", quoteAll("back"))
+	r.set("suspended", "false")
+	r.set("flag", "0")
+	r.set("interval", interval)
+	r.set("ease", "2.5")
+	r.set("repetition", "1")
+	r.set("due", "2024-05-10")
+	r.set("lapses", "0")
+	for _, review := range reviews {
+		guid, at, _ := strings.Cut(review, " ")
+		r.Values["review"] = append(r.Values["review"], guid+" "+at+" 4 1 2.5")
+	}
+	return r
+}
+
+func gitCollectionOf(records ...*gitRecord) gitCollection {
+	c := newGitCollection()
+	for _, r := range records {
+		c.add(r)
+	}
+	return c
+}
+
+func withValue(r *gitRecord, key string, values ...string) *gitRecord {
+	copy := newGitRecord(r.Kind, r.Guid)
+	for k, v := range r.Values {
+		copy.Values[k] = slices.Clone(v)
+	}
+	copy.set(key, values...)
+	return copy
+}
+
+func TestMergeGitDeletes(t *testing.T) {
+	card := gitCard("c1", "hola", "1")
+	edited := withValue(card, "front", quoteAll("adios"))
+
+	tests := []struct {
+		name   string
+		base   gitCollection
+		ours   gitCollection
+		theirs gitCollection
+		want   *gitRecord
+	}{
+		{"deleted by us", gitCollectionOf(card), gitCollectionOf(), gitCollectionOf(card), nil},
+		{"deleted by them", gitCollectionOf(card), gitCollectionOf(card), gitCollectionOf(), nil},
+		{"deleted by us, edited by them", gitCollectionOf(card), gitCollectionOf(), gitCollectionOf(edited), edited},
+		{"edited by us, deleted by them", gitCollectionOf(card), gitCollectionOf(edited), gitCollectionOf(), edited},
+		{"added by them", gitCollectionOf(), gitCollectionOf(), gitCollectionOf(card), card},
+		{"deleted by both", gitCollectionOf(card), gitCollectionOf(), gitCollectionOf(), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			merged, conflicts := mergeGit(tt.base, tt.ours, tt.theirs)
+			if got := merged["card"]["c1"]; !got.equal(tt.want) {
+				t.Errorf("merged card %v, want %v", got, tt.want)
+			}
+			if conflicts != 0 {
+				t.Errorf("%d conflicts, want none", conflicts)
+			}
+		})
+	}
+}
+
+func TestMergeGitRestoresReferencedParents(t *testing.T) {
+	note := newGitRecord("note", "n1")
+	note.set("deck", "d1")
+	note.set("type", "t1")
+	note.set("field", quoteAll("Front", "hola"))
+	card := gitCard("c1", "hola", "1")
+	reviewed := gitCard("c1", "hola", "6", "r1 2024-05-10T10:00:00Z")
+
+	// We deleted the note and its card, they reviewed the card meanwhile.
+	base := gitCollectionOf(note, card)
+	merged, _ := mergeGit(base, gitCollectionOf(), gitCollectionOf(note, reviewed))
+	if !merged["card"]["c1"].equal(reviewed) {
+		t.Errorf("card reviewed by them wasn't kept")
+	}
+	if !merged["note"]["n1"].equal(note) {
+		t.Errorf("note of the kept card wasn't restored")
+	}
+}
+
+func TestMergeGitRecord(t *testing.T) {
+	base := gitCard("c1", "hola", "1", "r1 2024-05-01T10:00:00Z")
+
+	t.Run("schedule from the side reviewed last", func(t *testing.T) {
+		ours := gitCard("c1", "hola", "6", "r1 2024-05-01T10:00:00Z", "r2 2024-05-03T10:00:00Z")
+		theirs := gitCard("c1", "hola", "3", "r1 2024-05-01T10:00:00Z", "r3 2024-05-04T10:00:00Z")
+		ours.set("lapses", "1")
+
+		merged, conflicts := mergeGitRecord(base, ours, theirs)
+		if conflicts != 0 {
+			t.Errorf("%d conflicts, want none", conflicts)
+		}
+		for _, key := range gitScheduleKeys {
+			if !slices.Equal(merged.Values[key], theirs.Values[key]) {
+				t.Errorf("%s = %v, want theirs %v", key, merged.Values[key], theirs.Values[key])
+			}
+		}
+		guids := []string{}
+		for _, review := range merged.Values["review"] {
+			guid, _, _ := strings.Cut(review, " ")
+			guids = append(guids, guid)
+		}
+		if want := []string{"r1", "r2", "r3"}; !slices.Equal(guids, want) {
+			t.Errorf("reviews %v, want %v", guids, want)
+		}
+
+		// The result doesn't depend on which side is ours.
+		swapped, _ := mergeGitRecord(base, theirs, ours)
+		if swapped.get("interval") != "3" || !slices.Equal(swapped.Values["review"], merged.Values["review"]) {
+			t.Errorf("merging the other way round gave interval %s and reviews %v", swapped.get("interval"), swapped.Values["review"])
+		}
+	})
+
+	t.Run("fields changed on one side", func(t *testing.T) {
+		ours := withValue(base, "front", quoteAll("hola!"))
+		theirs := withValue(base, "flag", "2")
+
+		merged, conflicts := mergeGitRecord(base, ours, theirs)
+		if conflicts != 0 {
+			t.Errorf("%d conflicts, want none", conflicts)
+		}
+		if merged.get("front") != quoteAll("hola!") || merged.get("flag") != "2" {
+			t.Errorf("front %s and flag %s, want both changes", merged.get("front"), merged.get("flag"))
+		}
+	})
+
+	t.Run("field changed on both sides", func(t *testing.T) {
+		ours := withValue(base, "front", quoteAll("ours"))
+		theirs := withValue(base, "front", quoteAll("theirs"))
+
+		merged, conflicts := mergeGitRecord(base, ours, theirs)
+		if conflicts != 1 {
+			t.Errorf("%d conflicts, want 1", conflicts)
+		}
+		if merged.get("front") != quoteAll("ours") {
+			t.Errorf("front %s, want ours", merged.get("front"))
+		}
+	})
+
+	t.Run("added on both sides", func(t *testing.T) {
+		ours := gitCard("c1", "hola", "1", "r1 2024-05-01T10:00:00Z")
+		theirs := gitCard("c1", "hola", "6", "r2 2024-05-02T10:00:00Z")
+
+		merged, _ := mergeGitRecord(nil, ours, theirs)
+		if merged.get("interval") != "6" || len(merged.Values["review"]) != 2 {
+			t.Errorf("interval %s and reviews %v, want theirs and both", merged.get("interval"), merged.Values["review"])
+		}
+	})
+}
+
+func runGit(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	out, err := gitRepo{dir: dir}.git(args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func cloneForTest(t *testing.T, remote string, dir string) gitRepo {
+	t.Helper()
+	runGit(t, filepath.Dir(dir), "clone", "--quiet", remote, dir)
+	runGit(t, dir, "config", "user.name", "Test")
+	runGit(t, dir, "config", "user.email", "test@example.com")
+	return gitRepo{dir: dir}
+}
+
+// TestGitSyncFailedLoadKeepsHead checks that upstream changes that can't
+// be loaded leave HEAD where it was, so they aren't deleted by the next
+// run.
+func TestGitSyncFailedLoadKeepsHead(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir := t.TempDir()
+	remote := filepath.Join(dir, "remote.git")
+	runGit(t, dir, "init", "--quiet", "--bare", remote)
+
+	now := localTime(2024, 5, 10, 9, 0)
+	dbA, _ := newTestDb(t, now)
+	addTestCard(t, dbA, addTestDeck(t, dbA, "Spanish"), "hola", "hello")
+	repoA := cloneForTest(t, remote, filepath.Join(dir, "a"))
+	dbB, _ := newTestDb(t, now)
+	repoB := cloneForTest(t, remote, filepath.Join(dir, "b"))
+
+	// Both devices commit before either has an upstream branch, B then
+	// merges the history A pushed.
+	if err := dbA.gitSync(repoA); err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, repoA.dir, "push", "--quiet", "-u", "origin", "HEAD")
+	if err := dbB.gitSync(repoB); err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, repoB.dir, "fetch", "--quiet")
+	runGit(t, repoB.dir, "branch", "--quiet", "--set-upstream-to", "origin/"+runGit(t, repoA.dir, "branch", "--show-current"))
+	if err := dbB.gitSync(repoB); err != nil {
+		t.Fatal(err)
+	}
+	if cards := dbB.getAllCards(); len(cards) != 1 || cards[0].Front != "hola" {
+		t.Fatalf("cards after the first sync: %v", cards)
+	}
+
+	// Upstream gets a valid card and one whose note doesn't exist.
+	deckId, _ := dbA.getDeckByName("Spanish")
+	addTestCard(t, dbA, deckId.Id, "adios", "bye")
+	if err := dbA.gitSync(repoA); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(repoA.dir, gitDeckDir, "spanish.txt")
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bad := "\ncard broken\nnote missing\nord 0\nfront \"x\"\nback \"y\"\nsuspended false\nflag 0\ninterval 0\nease 2.5\nrepetition 0\ndue 2024-05-10\nlapses 0\n"
+	if err := os.WriteFile(file, append(data, bad...), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, repoA.dir, "commit", "--quiet", "-am", "Broken card")
+	runGit(t, repoA.dir, "push", "--quiet")
+	tip := runGit(t, repoA.dir, "rev-parse", "HEAD")
+
+	head := runGit(t, repoB.dir, "rev-parse", "HEAD")
+	for run := 1; run <= 2; run++ {
+		if err := dbB.gitSync(repoB); err == nil {
+			t.Fatalf("run %d: loading the broken card succeeded", run)
+		}
+		if got := runGit(t, repoB.dir, "rev-parse", "HEAD"); got != head {
+			t.Fatalf("run %d: HEAD moved to %s after a failed load", run, got)
+		}
+		if got := runGit(t, remote, "rev-parse", "HEAD"); got != tip {
+			t.Fatalf("run %d: pushed %s over the upstream changes", run, got)
+		}
+	}
+	if cards := dbB.getAllCards(); len(cards) != 1 {
+		t.Errorf("%d cards after the failed loads, want 1", len(cards))
+	}
+}
@@ -0,0 +1,109 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// hookDir holds executables run on events, named after the event they
+// handle, such as card.reviewed, optionally followed by a dot and any
+// suffix to run several for one event: card.reviewed.tracker.
+const hookDir = dbFile + "-hooks"
+
+// hookPayload is written as JSON to the standard input of hooks. Only the
+// fields that apply to the event are set.
+type hookPayload struct {
+	schema
+	Event   string       `json:"event"`
+	Time    time.Time    `json:"time"`
+	Card    *cardRecord  `json:"card,omitempty"`
+	Grade   int          `json:"grade,omitempty"`
+	Deck    *hookDeck    `json:"deck,omitempty"`
+	Session *hookSession `json:"session,omitempty"`
+}
+
+type hookDeck struct {
+	Id   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+type hookSession struct {
+	Cards int `json:"cards"`
+	// Answers counts the grades given, not cards suspended or buried.
+	Answers int  `json:"answers"`
+	Preview bool `json:"preview"`
+	// Seconds is the length of the session, set once it has finished.
+	Seconds float64 `json:"seconds,omitempty"`
+}
+
+// hookPaths caches the hooks found for each event.
+var hookPaths = map[string][]string{}
+
+// findHooks returns the executables in hookDir handling event, in name
+// order.
+func findHooks(event string) []string {
+	if paths, ok := hookPaths[event]; ok {
+		return paths
+	}
+	paths := []string{}
+	matches, _ := filepath.Glob(filepath.Join(hookDir, event+"*"))
+	for _, path := range matches {
+		name := filepath.Base(path)
+		if name != event && !strings.HasPrefix(name, event+".") {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() || info.Mode().Perm()&0o111 == 0 {
+			continue
+		}
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	hookPaths[event] = paths
+	return paths
+}
+
+// fireHook runs the hooks of an event one after the other, each with the
+// payload on its standard input and at most hook-timeout seconds to finish.
+// A hook that fails or times out is reported and never stops playita.
+func (db *DB) fireHook(event string, payload hookPayload) {
+	paths := findHooks(event)
+	if len(paths) == 0 {
+		return
+	}
+	payload.schema = currentSchema
+	payload.Event = event
+	payload.Time = db.now().UTC()
+	data, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("Failed to encode %s event: %v", event, err)
+		return
+	}
+
+	timeout := time.Duration(db.getIntSetting("hook-timeout")) * time.Second
+	for _, path := range paths {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		cmd := exec.CommandContext(ctx, path)
+		cmd.Stdin = bytes.NewReader(data)
+		cmd.Env = append(os.Environ(), "PLAYITA_EVENT="+event)
+		// Output left open by processes the hook started doesn't keep
+		// playita waiting past the timeout.
+		cmd.WaitDelay = time.Second
+		output, err := cmd.CombinedOutput()
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			log.Printf("Hook %s timed out after %v", path, timeout)
+		} else if err != nil {
+			log.Printf("Hook %s failed: %v %s", path, err, strings.TrimSpace(string(output)))
+		}
+		cancel()
+	}
+}
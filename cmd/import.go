@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// importCmd represents the import command
+var importCmd = &cobra.Command{
+	Use:   "import <file>",
+	Short: "Import cards from a tab separated file",
+	Long: `
+Import notes from a file with one note per line and its fields separated
+by tabs, in the order the note type defines them. Empty lines and lines
+starting with # are skipped. Every line is checked before any note is
+added and the notes are added in one transaction, so a file with an
+invalid line imports nothing.
+	`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		deckName, _ := cmd.Flags().GetString("deck")
+
+		db := openDb()
+		nt := parseCardTypeFlag(cmd, db)
+		notes, err := readNotesFile(args[0], nt)
+		if err != nil {
+			log.Fatal("Failed to read import file ", err)
+		}
+
+		tags, _ := cmd.Flags().GetStringSlice("tags")
+		deck := db.findOrCreateDeck(deckName)
+		for _, note := range notes {
+			note.DeckId = deck.Id
+			note.Tags = formatTags(tags)
+		}
+		if err := db.insertNotes(notes); err != nil {
+			log.Fatal("Failed to import notes ", err)
+		}
+		fmt.Printf("Imported %d notes into %s\n", len(notes), deck.Name)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(importCmd)
+
+	importCmd.Flags().String("deck", "", "name of the deck, created when it does not exist")
+	addCardTypeFlag(importCmd)
+	importCmd.Flags().StringSlice("tags", []string{}, "comma separated tags added to every imported note")
+	importCmd.MarkFlagRequired("deck")
+}
+
+// readNotesFile reads the notes of an import file, reporting every invalid
+// line.
+func readNotesFile(path string, nt *NoteType) ([]*Note, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	notes := []*Note{}
+	invalid := []error{}
+	scanner := bufio.NewScanner(file)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(text) == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		values := strings.Split(text, "\t")
+		if len(values) > len(nt.Fields) {
+			invalid = append(invalid, fmt.Errorf("line %d: %d fields but %s only has %d", line, len(values), nt.Name, len(nt.Fields)))
+			continue
+		}
+		fields := map[string]string{}
+		for i, value := range values {
+			fields[nt.Fields[i]] = value
+		}
+		if _, err := nt.validateNote(fields); err != nil {
+			invalid = append(invalid, fmt.Errorf("line %d: %w", line, err))
+			continue
+		}
+		notes = append(notes, &Note{NoteTypeId: nt.Id, Fields: fields})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(invalid) > 0 {
+		return nil, errors.Join(invalid...)
+	}
+	return notes, nil
+}
@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeImportFile(t *testing.T, lines ...string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "notes.tsv")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadNotesFile(t *testing.T) {
+	nt := builtinNoteTypes[0]
+
+	notes, err := readNotesFile(writeImportFile(t, "# comment", "hola\thello", "", "adios\tbye"), &nt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(notes) != 2 || notes[1].Fields["Front"] != "adios" || notes[1].Fields["Back"] != "bye" {
+		t.Errorf("read notes %v", notes)
+	}
+
+	_, err = readNotesFile(writeImportFile(t, "hola\thello", "\tmissing front", "no back", "a\tb\tc"), &nt)
+	if err == nil {
+		t.Fatal("read a file with invalid lines")
+	}
+	for _, want := range []string{"line 2: front cannot be empty", "line 3: back of Card 1 card cannot be empty", "line 4: 3 fields"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q doesn't report %q", err, want)
+		}
+	}
+}
+
+func TestInsertNotesAddsAllOrNone(t *testing.T) {
+	db, _ := newTestDb(t, localTime(2024, 5, 10, 9, 0))
+	deckId := addTestDeck(t, db, "Spanish")
+	nt, err := db.getNoteTypeByName(NoteTypeReversed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	note := func(front string, back string) *Note {
+		return &Note{DeckId: deckId, NoteTypeId: nt.Id, Fields: map[string]string{"Front": front, "Back": back}}
+	}
+
+	if err := db.insertNotes([]*Note{note("hola", "hello"), note("adios", "")}); err == nil {
+		t.Fatal("inserted a note without a back")
+	}
+	if cards := db.getAllCards(); len(cards) != 0 {
+		t.Fatalf("%d cards added by a failed import", len(cards))
+	}
+
+	if err := db.insertNotes([]*Note{note("hola", "hello"), note("adios", "bye")}); err != nil {
+		t.Fatal(err)
+	}
+	if cards := db.getAllCards(); len(cards) != 4 {
+		t.Errorf("%d cards, want 4", len(cards))
+	}
+}
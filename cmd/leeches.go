@@ -0,0 +1,203 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+const leechTag = "leech"
+
+// leechesCmd represents the leeches command
+var leechesCmd = &cobra.Command{
+	Use:   "leeches",
+	Short: "List cards that keep being forgotten",
+	Long: `
+List cards whose lapses reached the leech-threshold setting, with the dates
+and scores of their failed reviews, so they can be rewritten.
+
+A lapse is counted at most once a day per card. When a card reaches the
+threshold, and again every half threshold after it, its note is tagged
+"leech" and, when leech-action is set to suspend, the card is suspended.
+
+With --output json or jsonl each leech is written as:
+
+  {"schema_version": 1, "id": 7, "front": "hola", "lapses": 9,
+   "suspended": true, "failures": [{"date": "2024-05-01", "grade": 2}]}
+	`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		deckName, _ := cmd.Flags().GetString("deck")
+		history, _ := cmd.Flags().GetInt("history")
+
+		db := openDb()
+		deckId := 0
+		if deckName != "" {
+			deck, err := db.getDeckByName(deckName)
+			if err != nil {
+				log.Fatalf("Deck %q not found\n", deckName)
+			}
+			deckId = deck.Id
+		}
+
+		leeches := db.getLeeches(deckId)
+		if len(leeches) == 0 && tableOutput() {
+			fmt.Print("No leeches found 🥳 \n ")
+			return
+		}
+
+		records := []leechRecord{}
+		for _, card := range leeches {
+			records = append(records, leechRecord{
+				schema:    currentSchema,
+				Id:        card.Id,
+				Front:     card.Front,
+				Lapses:    card.Lapses,
+				Suspended: card.Suspended,
+				Failures:  db.getFailureHistory(card.Id, history),
+			})
+		}
+		printRecords(records, leechOutputColumns)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(leechesCmd)
+
+	leechesCmd.Flags().String("deck", "", "only list leeches of this deck")
+	leechesCmd.Flags().Int("history", 5, "number of most recent failures to show")
+}
+
+type leechRecord struct {
+	schema
+	Id        int             `json:"id"`
+	Front     string          `json:"front"`
+	Lapses    int             `json:"lapses"`
+	Suspended bool            `json:"suspended"`
+	Failures  []reviewFailure `json:"failures"`
+}
+
+type reviewFailure struct {
+	Date  string `json:"date"`
+	Grade int    `json:"grade"`
+}
+
+var leechOutputColumns = []outputColumn[leechRecord]{
+	{"id", func(l leechRecord) string { return strconv.Itoa(l.Id) }},
+	{"front", func(l leechRecord) string {
+		if tableOutput() {
+			return summarize(l.Front, 40)
+		}
+		return l.Front
+	}},
+	{"lapses", func(l leechRecord) string { return strconv.Itoa(l.Lapses) }},
+	{"suspended", func(l leechRecord) string { return strconv.FormatBool(l.Suspended) }},
+	{"failed_on", func(l leechRecord) string {
+		failures := []string{}
+		for _, f := range l.Failures {
+			failures = append(failures, fmt.Sprintf("%s (%d)", f.Date, f.Grade))
+		}
+		return strings.Join(failures, ", ")
+	}},
+}
+
+// reachedLeechThreshold reports whether the latest lapse makes the card a
+// leech: at the threshold and every half threshold after it.
+func (db *DB) reachedLeechThreshold(c *BaseCard) bool {
+	threshold := db.getIntSetting("leech-threshold")
+	if threshold <= 0 || c.Lapses < threshold {
+		return false
+	}
+	return (c.Lapses-threshold)%max(threshold/2, 1) == 0
+}
+
+// handleLeech tags the note of a leech and suspends it when configured to,
+// reporting whether the card was suspended.
+func (db *DB) handleLeech(c *BaseCard) bool {
+	if err := db.addNoteTag(c.NoteId, leechTag); err != nil {
+		fmt.Printf("Failed to tag card Id: %v with error: %v", c.Id, err)
+	}
+	if db.getSetting("leech-action") != "suspend" {
+		return false
+	}
+	db.setSuspended(c.Id, true)
+	c.Suspended = true
+	return true
+}
+
+func (db *DB) addNoteTag(noteId int, tag string) error {
+	note, err := db.getNote(noteId)
+	if err != nil {
+		return err
+	}
+	note.Tags = formatTags([]string{note.Tags, tag})
+	_, err = db.db.Exec("UPDATE Notes SET Tags = ? WHERE Id = ?", note.Tags, noteId)
+	return err
+}
+
+// failedToday reports whether the card already has a failed review today.
+func (db *DB) failedToday(cardId int) bool {
+	var count int
+	stmt := "SELECT COUNT(*) FROM Reviews WHERE CardId = ? AND Grade <= 3 AND datetime(ReviewedAt) >= datetime(?)"
+	if err := db.db.QueryRow(stmt, cardId, db.dayStart(db.today())).Scan(&count); err != nil {
+		log.Printf("Error occurred whilst reading reviews of card Id: %v - error: %v", cardId, err)
+	}
+	return count > 0
+}
+
+func (db *DB) getLeeches(deckId int) []BaseCard {
+	stmt := "SELECT " + cardColumns + " FROM Cards WHERE (Lapses >= ? AND ? > 0 OR NoteId IN (SELECT Id FROM Notes WHERE " + hasTag + ")) AND (? = 0 OR DeckId = ?) ORDER BY Lapses DESC, Id"
+	threshold := db.getIntSetting("leech-threshold")
+	rows, err := db.db.Query(stmt, threshold, threshold, tagPattern(leechTag), deckId, deckId)
+	if err != nil {
+		log.Fatal("Error querying for cards", err)
+	}
+	defer rows.Close()
+
+	cards := []BaseCard{}
+	for rows.Next() {
+		i, err := scanCard(rows)
+		if err != nil {
+			log.Printf("Error occurred whilst mapping cards Id: %v - error: %v", &i.Id, err)
+		}
+		cards = append(cards, i)
+	}
+	return cards
+}
+
+// getFailureHistory returns the most recent failed reviews of a card,
+// oldest first.
+func (db *DB) getFailureHistory(cardId int, limit int) []reviewFailure {
+	stmt := "SELECT ReviewedAt, Grade FROM (SELECT ReviewedAt, Grade FROM Reviews WHERE CardId = ? AND Grade <= 3 ORDER BY datetime(ReviewedAt) DESC LIMIT ?) ORDER BY datetime(ReviewedAt)"
+	rows, err := db.db.Query(stmt, cardId, limit)
+	if err != nil {
+		log.Fatal("Error querying for reviews", err)
+	}
+	defer rows.Close()
+
+	history := []reviewFailure{}
+	for rows.Next() {
+		var reviewedAt time.Time
+		var grade int
+		if err := rows.Scan(&reviewedAt, &grade); err != nil {
+			log.Printf("Error occurred whilst mapping reviews of card Id: %v - error: %v", cardId, err)
+			continue
+		}
+		history = append(history, reviewFailure{Date: db.studyDay(reviewedAt).Format("2006-01-02"), Grade: grade})
+	}
+	return history
+}
+
+// summarize shortens text to a single line of at most width characters.
+func summarize(text string, width int) string {
+	text = strings.Join(strings.Fields(text), " ")
+	runes := []rune(text)
+	if len(runes) <= width {
+		return text
+	}
+	return string(runes[:width-1]) + "…"
+}
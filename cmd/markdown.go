@@ -0,0 +1,410 @@
+package cmd
+
+import (
+	"os"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/chzyer/readline"
+)
+
+const (
+	ansiBold         = "\x1b[1m"
+	ansiFaint        = "\x1b[2m"
+	ansiItalic       = "\x1b[3m"
+	ansiUnderline    = "\x1b[4m"
+	ansiNormal       = "\x1b[22m"
+	ansiNoItalic     = "\x1b[23m"
+	ansiNoUnderline  = "\x1b[24m"
+	ansiGreen        = "\x1b[32m"
+	ansiYellow       = "\x1b[33m"
+	ansiBlue         = "\x1b[34m"
+	ansiMagenta      = "\x1b[35m"
+	ansiCyan         = "\x1b[36m"
+	ansiDefaultColor = "\x1b[39m"
+	ansiReset        = "\x1b[0m"
+)
+
+const defaultTerminalWidth = 80
+
+// plainOutput disables Markdown rendering, set by --plain or when stdout is
+// not a terminal.
+var plainOutput bool
+
+func init() {
+	rootCmd.PersistentFlags().BoolVar(&plainOutput, "plain", false, "print card content as plain text instead of rendered Markdown")
+}
+
+// renderCardContent formats the front or back of a card for printing.
+func renderCardContent(text string) string {
+	if !colorEnabled() {
+		return text
+	}
+	return renderMarkdown(text, terminalWidth())
+}
+
+// colorEnabled reports whether output may contain ANSI escape codes.
+func colorEnabled() bool {
+	return !plainOutput && os.Getenv("NO_COLOR") == "" && readline.IsTerminal(int(os.Stdout.Fd()))
+}
+
+func terminalWidth() int {
+	if width := readline.GetScreenWidth(); width > 0 {
+		return width
+	}
+	return defaultTerminalWidth
+}
+
+var (
+	fencePattern     = regexp.MustCompile("^\\s*(```|~~~)\\s*([\\w+#-]*)")
+	headingPattern   = regexp.MustCompile(`^\s*(#{1,6})\s+(.*?)\s*#*\s*$`)
+	listPattern      = regexp.MustCompile(`^(\s*)([-*+]|\d+[.)])\s+(.*)$`)
+	quotePattern     = regexp.MustCompile(`^\s*>\s?(.*)$`)
+	rulePattern      = regexp.MustCompile(`^\s*((-\s*){3,}|(\*\s*){3,}|(_\s*){3,})$`)
+	tableSepPattern  = regexp.MustCompile(`^\s*\|?\s*:?-+:?\s*(\|\s*:?-+:?\s*)*\|?\s*$`)
+	ansiEscape       = regexp.MustCompile("\x1b\\[[0-9;]*m")
+	inlinePattern    = regexp.MustCompile("`[^`]+`|\\*\\*[^*]+\\*\\*|__[^_]+__|\\*[^*\\s][^*]*\\*|\\b_[^_\\s][^_]*_\\b|\\[[^\\]]+\\]\\([^)]+\\)")
+	markdownLinkPart = regexp.MustCompile(`^\[([^\]]+)\]\(([^)]+)\)$`)
+)
+
+// renderMarkdown renders a subset of Markdown with ANSI escape codes:
+// headings, emphasis, inline code, links, lists, block quotes, rules, tables
+// and fenced code blocks, which are syntax highlighted. Text is wrapped to
+// width.
+func renderMarkdown(text string, width int) string {
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	out := []string{}
+	paragraph := []string{}
+
+	flush := func() {
+		if len(paragraph) > 0 {
+			out = append(out, wrapText(renderInline(strings.Join(paragraph, " ")), width, "", "")...)
+			paragraph = paragraph[:0]
+		}
+	}
+
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+
+		if m := fencePattern.FindStringSubmatch(line); m != nil {
+			flush()
+			code := []string{}
+			for i++; i < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[i]), m[1]); i++ {
+				code = append(code, lines[i])
+			}
+			for _, l := range highlightCode(code, m[2]) {
+				out = append(out, "  "+l)
+			}
+			continue
+		}
+
+		if isTableStart(lines, i) {
+			flush()
+			rows := [][]string{splitTableRow(line)}
+			for i += 2; i < len(lines) && strings.Contains(lines[i], "|"); i++ {
+				rows = append(rows, splitTableRow(lines[i]))
+			}
+			i--
+			out = append(out, renderTable(rows)...)
+			continue
+		}
+
+		switch {
+		case strings.TrimSpace(line) == "":
+			flush()
+			if len(out) > 0 && out[len(out)-1] != "" {
+				out = append(out, "")
+			}
+		case headingPattern.MatchString(line):
+			flush()
+			m := headingPattern.FindStringSubmatch(line)
+			out = append(out, wrapText(ansiBold+ansiUnderline+renderInline(m[2])+ansiNoUnderline+ansiNormal, width, "", "")...)
+		case rulePattern.MatchString(line):
+			flush()
+			out = append(out, ansiFaint+strings.Repeat("─", min(width, defaultTerminalWidth))+ansiNormal)
+		case listPattern.MatchString(line):
+			flush()
+			m := listPattern.FindStringSubmatch(line)
+			indent := strings.Repeat(" ", utf8.RuneCountInString(m[1]))
+			marker := "• "
+			if unicode.IsDigit(rune(m[2][0])) {
+				marker = m[2] + " "
+			}
+			hanging := indent + strings.Repeat(" ", utf8.RuneCountInString(marker))
+			out = append(out, wrapText(renderInline(m[3]), width, indent+marker, hanging)...)
+		case quotePattern.MatchString(line):
+			flush()
+			m := quotePattern.FindStringSubmatch(line)
+			prefix := ansiFaint + "│ " + ansiNormal
+			out = append(out, wrapText(ansiItalic+renderInline(m[1])+ansiNoItalic, width, prefix, prefix)...)
+		default:
+			paragraph = append(paragraph, strings.TrimSpace(line))
+		}
+	}
+	flush()
+
+	for len(out) > 0 && out[len(out)-1] == "" {
+		out = out[:len(out)-1]
+	}
+	return strings.Join(out, "\n")
+}
+
+func renderInline(text string) string {
+	return inlinePattern.ReplaceAllStringFunc(text, func(token string) string {
+		switch {
+		case strings.HasPrefix(token, "`"):
+			return ansiCyan + strings.Trim(token, "`") + ansiDefaultColor
+		case strings.HasPrefix(token, "**"), strings.HasPrefix(token, "__"):
+			return ansiBold + renderInline(token[2:len(token)-2]) + ansiNormal
+		case strings.HasPrefix(token, "["):
+			m := markdownLinkPart.FindStringSubmatch(token)
+			return ansiUnderline + m[1] + ansiNoUnderline + ansiFaint + " (" + m[2] + ")" + ansiNormal
+		default:
+			return ansiItalic + renderInline(token[1:len(token)-1]) + ansiNoItalic
+		}
+	})
+}
+
+// wrapText breaks text into lines no wider than width, not counting ANSI
+// escape codes. The first line starts with prefix, the others with
+// continuation.
+func wrapText(text string, width int, prefix string, continuation string) []string {
+	available := width - visibleWidth(prefix)
+	if available < 10 {
+		available = 10
+	}
+
+	lines := []string{}
+	current := ""
+	for _, word := range strings.Fields(text) {
+		switch {
+		case current == "":
+			current = word
+		case visibleWidth(current)+1+visibleWidth(word) <= available:
+			current += " " + word
+		default:
+			lines = append(lines, current)
+			current = word
+		}
+	}
+	lines = append(lines, current)
+
+	for i := range lines {
+		if i == 0 {
+			lines[i] = prefix + lines[i]
+		} else {
+			lines[i] = continuation + lines[i]
+		}
+	}
+	return lines
+}
+
+func visibleWidth(s string) int {
+	return utf8.RuneCountInString(ansiEscape.ReplaceAllString(s, ""))
+}
+
+func isTableStart(lines []string, i int) bool {
+	return strings.Contains(lines[i], "|") && i+1 < len(lines) && strings.Contains(lines[i+1], "-") && tableSepPattern.MatchString(lines[i+1])
+}
+
+func splitTableRow(line string) []string {
+	line = strings.TrimSpace(line)
+	line = strings.TrimPrefix(line, "|")
+	line = strings.TrimSuffix(line, "|")
+	cells := strings.Split(line, "|")
+	for i := range cells {
+		cells[i] = renderInline(strings.TrimSpace(cells[i]))
+	}
+	return cells
+}
+
+func renderTable(rows [][]string) []string {
+	widths := []int{}
+	for _, row := range rows {
+		for i, cell := range row {
+			if i >= len(widths) {
+				widths = append(widths, 0)
+			}
+			widths[i] = max(widths[i], visibleWidth(cell))
+		}
+	}
+
+	border := func(left, middle, right string) string {
+		parts := []string{}
+		for _, w := range widths {
+			parts = append(parts, strings.Repeat("─", w+2))
+		}
+		return ansiFaint + left + strings.Join(parts, middle) + right + ansiNormal
+	}
+	separator := ansiFaint + "│" + ansiNormal
+
+	out := []string{border("┌", "┬", "┐")}
+	for r, row := range rows {
+		line := separator
+		for i, w := range widths {
+			cell := ""
+			if i < len(row) {
+				cell = row[i]
+			}
+			if r == 0 {
+				cell = ansiBold + cell + ansiNormal
+			}
+			line += " " + cell + strings.Repeat(" ", w-visibleWidth(cell)) + " " + separator
+		}
+		out = append(out, line)
+		if r == 0 {
+			out = append(out, border("├", "┼", "┤"))
+		}
+	}
+	return append(out, border("└", "┴", "┘"))
+}
+
+// codeLanguage describes just enough of a language to colour it.
+type codeLanguage struct {
+	keywords       []string
+	lineComments   []string
+	blockComments  bool
+	backtickString bool
+}
+
+var codeLanguages = map[string]codeLanguage{
+	"go": {
+		keywords:       strings.Fields("break case chan const continue default defer else fallthrough for func go goto if import interface map package range return select struct switch type var nil true false"),
+		lineComments:   []string{"//"},
+		blockComments:  true,
+		backtickString: true,
+	},
+	"python": {
+		keywords:     strings.Fields("and as assert async await break class continue def del elif else except False finally for from global if import in is lambda None nonlocal not or pass raise return True try while with yield"),
+		lineComments: []string{"#"},
+	},
+	"javascript": {
+		keywords:       strings.Fields("async await break case catch class const continue default delete do else export extends false finally for function if import in instanceof let new null return super switch this throw true try typeof undefined var void while yield"),
+		lineComments:   []string{"//"},
+		blockComments:  true,
+		backtickString: true,
+	},
+	"c": {
+		keywords:      strings.Fields("abstract auto bool boolean break case catch char class const continue default do double else enum extends extern false final float for if implements import int interface long namespace new null private protected public return short signed static struct switch this throw true try typedef unsigned using var void volatile while"),
+		lineComments:  []string{"//"},
+		blockComments: true,
+	},
+	"rust": {
+		keywords:      strings.Fields("as break const continue crate else enum extern false fn for if impl in let loop match mod move mut pub ref return self Self static struct super trait true type unsafe use where while"),
+		lineComments:  []string{"//"},
+		blockComments: true,
+	},
+	"shell": {
+		keywords:     strings.Fields("if then else elif fi case esac for while until do done in function return export local"),
+		lineComments: []string{"#"},
+	},
+	"sql": {
+		keywords:      strings.Fields("select from where and or not insert into values update set delete create table drop alter join left right inner outer on group by order having limit as distinct null is in like"),
+		lineComments:  []string{"--"},
+		blockComments: true,
+	},
+}
+
+var codeLanguageAliases = map[string]string{
+	"golang": "go", "py": "python", "js": "javascript", "ts": "javascript",
+	"typescript": "javascript", "jsx": "javascript", "tsx": "javascript",
+	"cpp": "c", "c++": "c", "h": "c", "java": "c", "cs": "c", "csharp": "c",
+	"kotlin": "c", "rs": "rust", "sh": "shell", "bash": "shell", "zsh": "shell",
+}
+
+// highlightCode colours keywords, strings, numbers and comments of a fenced
+// code block. Unknown languages only get strings and numbers, as there is no
+// telling what starts a comment in them.
+func highlightCode(lines []string, language string) []string {
+	language = strings.ToLower(language)
+	if alias, ok := codeLanguageAliases[language]; ok {
+		language = alias
+	}
+	lang := codeLanguages[language]
+	keywords := map[string]bool{}
+	for _, k := range lang.keywords {
+		keywords[k] = true
+		if language == "sql" {
+			keywords[strings.ToUpper(k)] = true
+		}
+	}
+
+	out := []string{}
+	inBlockComment := false
+	for _, line := range lines {
+		var b strings.Builder
+		runes := []rune(strings.ReplaceAll(line, "\t", "    "))
+		for i := 0; i < len(runes); {
+			rest := string(runes[i:])
+			switch {
+			case inBlockComment:
+				end := strings.Index(rest, "*/")
+				if end < 0 {
+					b.WriteString(ansiFaint + rest + ansiNormal)
+					i = len(runes)
+					continue
+				}
+				comment := rest[:end+2]
+				b.WriteString(ansiFaint + comment + ansiNormal)
+				i += utf8.RuneCountInString(comment)
+				inBlockComment = false
+			case lang.blockComments && strings.HasPrefix(rest, "/*"):
+				inBlockComment = true
+				b.WriteString(ansiFaint + "/*" + ansiNormal)
+				i += 2
+			case hasAnyPrefix(rest, lang.lineComments):
+				b.WriteString(ansiFaint + rest + ansiNormal)
+				i = len(runes)
+			case runes[i] == '"' || runes[i] == '\'' || (runes[i] == '`' && lang.backtickString):
+				j := i + 1
+				for j < len(runes) && runes[j] != runes[i] {
+					if runes[j] == '\\' {
+						j++
+					}
+					j++
+				}
+				j = min(j+1, len(runes))
+				b.WriteString(ansiGreen + string(runes[i:j]) + ansiDefaultColor)
+				i = j
+			case unicode.IsDigit(runes[i]):
+				j := i
+				for j < len(runes) && (unicode.IsDigit(runes[j]) || unicode.IsLetter(runes[j]) || runes[j] == '.' || runes[j] == '_') {
+					j++
+				}
+				b.WriteString(ansiYellow + string(runes[i:j]) + ansiDefaultColor)
+				i = j
+			case unicode.IsLetter(runes[i]) || runes[i] == '_':
+				j := i
+				for j < len(runes) && (unicode.IsLetter(runes[j]) || unicode.IsDigit(runes[j]) || runes[j] == '_') {
+					j++
+				}
+				word := string(runes[i:j])
+				switch {
+				case keywords[word]:
+					b.WriteString(ansiMagenta + word + ansiDefaultColor)
+				case j < len(runes) && runes[j] == '(':
+					b.WriteString(ansiBlue + word + ansiDefaultColor)
+				default:
+					b.WriteString(word)
+				}
+				i = j
+			default:
+				b.WriteRune(runes[i])
+				i++
+			}
+		}
+		out = append(out, b.String()+ansiReset)
+	}
+	return out
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,43 @@
+package cmd
+
+import "testing"
+
+func TestRenderMarkdown(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"plain text", "hola, ¿qué tal?", "hola, ¿qué tal?"},
+		{"snake case", "call my_var_name", "call my_var_name"},
+		{"bold", "**hola** amigo", ansiBold + "hola" + ansiNormal + " amigo"},
+		{"italic", "una *palabra*", "una " + ansiItalic + "palabra" + ansiNoItalic},
+		{"underscore italic", "una _palabra_", "una " + ansiItalic + "palabra" + ansiNoItalic},
+		{"inline code", "run `ls`", "run " + ansiCyan + "ls" + ansiDefaultColor},
+		{"bullet list", "- uno\n* dos", "• uno\n• dos"},
+		{"numbered list", "1. uno\n2) dos", "1. uno\n2) dos"},
+		{"nested list", "- uno\n  - dos", "• uno\n  • dos"},
+		{"paragraphs", "uno\ndos\n\ntres", "uno dos\n\ntres"},
+		{"known language", "```go\nreturn x // done\n```",
+			"  " + ansiMagenta + "return" + ansiDefaultColor + " x " + ansiFaint + "// done" + ansiNormal + ansiReset},
+		{"alias", "```py\nx = 1 # one\n```",
+			"  x = " + ansiYellow + "1" + ansiDefaultColor + " " + ansiFaint + "# one" + ansiNormal + ansiReset},
+		{"unknown language", "```text\n# not a comment\nsay \"hi\"\n```",
+			"  # not a comment" + ansiReset + "\n  say " + ansiGreen + "\"hi\"" + ansiDefaultColor + ansiReset},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := renderMarkdown(tt.text, defaultTerminalWidth); got != tt.want {
+				t.Errorf("renderMarkdown(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWrapText(t *testing.T) {
+	got := wrapText(ansiBold+"uno"+ansiNormal+" dos tres cuatro", 13, "• ", "  ")
+	want := []string{"• " + ansiBold + "uno" + ansiNormal + " dos", "  tres cuatro"}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("wrapText() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,635 @@
+package cmd
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+const (
+	NoteTypeBasic    = "Basic"
+	NoteTypeReversed = "Basic (and reversed card)"
+)
+
+// builtinNoteTypes are created on startup and cannot be renamed.
+var builtinNoteTypes = []NoteType{
+	{
+		Name:   NoteTypeBasic,
+		Fields: []string{"Front", "Back"},
+		Templates: []CardTemplate{
+			{Name: "Card 1", Front: "{{.Front}}", Back: "{{.Back}}"},
+		},
+	},
+	{
+		Name:   NoteTypeReversed,
+		Fields: []string{"Front", "Back"},
+		Templates: []CardTemplate{
+			{Name: "Card 1", Front: "{{.Front}}", Back: "{{.Back}}"},
+			{Name: "Card 2", Front: "{{.Back}}", Back: "{{.Front}}"},
+		},
+	},
+}
+
+// frontSideField gives back templates access to the rendered front.
+const frontSideField = "FrontSide"
+
+var fieldNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+type NoteType struct {
+	Id        int
+	Name      string
+	Fields    []string
+	Templates []CardTemplate
+}
+
+type CardTemplate struct {
+	Name  string
+	Front string
+	Back  string
+}
+
+type Note struct {
+	Id         int
+	DeckId     int
+	NoteTypeId int
+	Fields     map[string]string
+	// Tags is a space separated list of tags.
+	Tags string
+}
+
+// renderedCard is the front and back a template produces for a note.
+type renderedCard struct {
+	Ord   int
+	Front string
+	Back  string
+}
+
+// hasTag is the SQL condition matching notes with the tag given by a
+// tagPattern among their tags.
+const hasTag = `' ' || Tags || ' ' LIKE ? ESCAPE '\'`
+
+// tagPattern is the LIKE pattern of hasTag for tag, in which % and _ match
+// themselves.
+func tagPattern(tag string) string {
+	return "% " + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(tag) + " %"
+}
+
+// formatTags normalises tags into the space separated form stored on notes.
+func formatTags(tags []string) string {
+	seen := map[string]bool{}
+	out := []string{}
+	for _, tag := range tags {
+		for _, t := range strings.Fields(strings.ReplaceAll(tag, ",", " ")) {
+			if !seen[t] {
+				seen[t] = true
+				out = append(out, t)
+			}
+		}
+	}
+	return strings.Join(out, " ")
+}
+
+func isBuiltinNoteType(name string) bool {
+	for _, nt := range builtinNoteTypes {
+		if nt.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// validate checks the field names and that every template only refers to
+// fields of the note type.
+func (nt *NoteType) validate() error {
+	if strings.TrimSpace(nt.Name) == "" {
+		return errors.New("name of note type cannot be empty")
+	}
+	if len(nt.Fields) == 0 {
+		return errors.New("note type needs at least one field")
+	}
+	if len(nt.Templates) == 0 {
+		return errors.New("note type needs at least one card template")
+	}
+
+	sample := map[string]string{}
+	for _, field := range nt.Fields {
+		if !fieldNamePattern.MatchString(field) {
+			return fmt.Errorf("invalid field name %q: use letters, digits and underscores", field)
+		}
+		if field == frontSideField {
+			return fmt.Errorf("field name %q is reserved", field)
+		}
+		if _, ok := sample[field]; ok {
+			return fmt.Errorf("duplicate field %q", field)
+		}
+		sample[field] = field
+	}
+
+	for _, t := range nt.Templates {
+		if _, err := executeTemplate(t.Name+" front", t.Front, sample, true); err != nil {
+			return err
+		}
+		sample[frontSideField] = frontSideField
+		_, err := executeTemplate(t.Name+" back", t.Back, sample, true)
+		delete(sample, frontSideField)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// render produces the cards of a note. Templates whose front renders empty
+// do not generate a card.
+func (nt *NoteType) render(fields map[string]string) ([]renderedCard, error) {
+	cards := []renderedCard{}
+	for ord, t := range nt.Templates {
+		data := map[string]string{}
+		for _, field := range nt.Fields {
+			data[field] = fields[field]
+		}
+		front, err := executeTemplate(t.Name+" front", t.Front, data, false)
+		if err != nil {
+			return nil, err
+		}
+		if strings.TrimSpace(front) == "" {
+			continue
+		}
+		data[frontSideField] = front
+		back, err := executeTemplate(t.Name+" back", t.Back, data, false)
+		if err != nil {
+			return nil, err
+		}
+		cards = append(cards, renderedCard{Ord: ord, Front: front, Back: back})
+	}
+	return cards, nil
+}
+
+// validateNote renders the cards of a note, rejecting it when its first
+// field or the back of any of its cards is empty.
+func (nt *NoteType) validateNote(fields map[string]string) ([]renderedCard, error) {
+	if strings.TrimSpace(fields[nt.Fields[0]]) == "" {
+		return nil, fmt.Errorf("%s cannot be empty", strings.ToLower(nt.Fields[0]))
+	}
+	rendered, err := nt.render(fields)
+	if err != nil {
+		return nil, err
+	}
+	if len(rendered) == 0 {
+		return nil, fmt.Errorf("note generates no cards, fill in the %v field", nt.Fields[0])
+	}
+	for _, card := range rendered {
+		if strings.TrimSpace(card.Back) == "" {
+			return nil, fmt.Errorf("back of %s card cannot be empty", nt.Templates[card.Ord].Name)
+		}
+	}
+	return rendered, nil
+}
+
+func executeTemplate(name string, text string, data map[string]string, strict bool) (string, error) {
+	missingKey := "missingkey=zero"
+	if strict {
+		missingKey = "missingkey=error"
+	}
+	tmpl, err := template.New(name).Option(missingKey).Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var out strings.Builder
+	if err := tmpl.Execute(&out, data); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+func (db *DB) getNoteTypes() ([]NoteType, error) {
+	return queryNoteTypes(db.db)
+}
+
+// queryNoteTypes reads every note type with its templates, through a
+// transaction when q is one.
+func queryNoteTypes(q querier) ([]NoteType, error) {
+	rows, err := q.Query("SELECT Id, Name, Fields FROM NoteTypes ORDER BY Id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	noteTypes := []NoteType{}
+	for rows.Next() {
+		nt := NoteType{}
+		var fields string
+		if err := rows.Scan(&nt.Id, &nt.Name, &fields); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(fields), &nt.Fields); err != nil {
+			return nil, fmt.Errorf("note type %v has invalid fields: %w", nt.Name, err)
+		}
+		noteTypes = append(noteTypes, nt)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	for i := range noteTypes {
+		if noteTypes[i].Templates, err = queryCardTemplates(q, noteTypes[i].Id); err != nil {
+			return nil, err
+		}
+	}
+	return noteTypes, nil
+}
+
+func (db *DB) getCardTemplates(noteTypeId int) ([]CardTemplate, error) {
+	return queryCardTemplates(db.db, noteTypeId)
+}
+
+func queryCardTemplates(q querier, noteTypeId int) ([]CardTemplate, error) {
+	rows, err := q.Query("SELECT Name, Front, Back FROM CardTemplates WHERE NoteTypeId = ? ORDER BY Ord", noteTypeId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	templates := []CardTemplate{}
+	for rows.Next() {
+		t := CardTemplate{}
+		if err := rows.Scan(&t.Name, &t.Front, &t.Back); err != nil {
+			return nil, err
+		}
+		templates = append(templates, t)
+	}
+	return templates, rows.Err()
+}
+
+func (db *DB) getNoteType(noteTypeId int) (*NoteType, error) {
+	noteTypes, err := db.getNoteTypes()
+	if err != nil {
+		return nil, err
+	}
+	for _, nt := range noteTypes {
+		if nt.Id == noteTypeId {
+			return &nt, nil
+		}
+	}
+	return nil, fmt.Errorf("note type id: %v not found", noteTypeId)
+}
+
+func (db *DB) getNoteTypeByName(name string) (*NoteType, error) {
+	noteTypes, err := db.getNoteTypes()
+	if err != nil {
+		return nil, err
+	}
+	for _, nt := range noteTypes {
+		if strings.EqualFold(nt.Name, name) {
+			return &nt, nil
+		}
+	}
+	return nil, fmt.Errorf("note type %q not found", name)
+}
+
+// saveNoteType inserts or updates a note type and re-renders the cards of
+// every note using it.
+func (db *DB) saveNoteType(nt *NoteType) error {
+	if err := nt.validate(); err != nil {
+		return err
+	}
+	fields, err := json.Marshal(nt.Fields)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if nt.Id == 0 {
+		res, err := tx.Exec("INSERT INTO NoteTypes(Name, Fields) VALUES (?, ?)", nt.Name, string(fields))
+		if err != nil {
+			return err
+		}
+		id, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+		nt.Id = int(id)
+	} else if _, err := tx.Exec("UPDATE NoteTypes SET Name = ?, Fields = ? WHERE Id = ?", nt.Name, string(fields), nt.Id); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("DELETE FROM CardTemplates WHERE NoteTypeId = ?", nt.Id); err != nil {
+		return err
+	}
+	for ord, t := range nt.Templates {
+		stmt := "INSERT INTO CardTemplates(NoteTypeId, Ord, Name, Front, Back) VALUES (?, ?, ?, ?, ?)"
+		if _, err := tx.Exec(stmt, nt.Id, ord, t.Name, t.Front, t.Back); err != nil {
+			return err
+		}
+	}
+
+	notes, err := queryNotes(tx, "SELECT Id, DeckId, NoteTypeId, Fields, Tags FROM Notes WHERE NoteTypeId = ?", nt.Id)
+	if err != nil {
+		return err
+	}
+	for _, note := range notes {
+		if err := db.syncNoteCards(tx, note, nt); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (db *DB) getNote(noteId int) (*Note, error) {
+	notes, err := queryNotes(db.db, "SELECT Id, DeckId, NoteTypeId, Fields, Tags FROM Notes WHERE Id = ?", noteId)
+	if err != nil {
+		return nil, err
+	}
+	if len(notes) == 0 {
+		return nil, fmt.Errorf("note id: %v not found", noteId)
+	}
+	return notes[0], nil
+}
+
+type querier interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+}
+
+func queryNotes(q querier, stmt string, args ...any) ([]*Note, error) {
+	rows, err := q.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	notes := []*Note{}
+	for rows.Next() {
+		note := Note{}
+		var fields string
+		if err := rows.Scan(&note.Id, &note.DeckId, &note.NoteTypeId, &fields, &note.Tags); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(fields), &note.Fields); err != nil {
+			return nil, fmt.Errorf("note id: %v has invalid fields: %w", note.Id, err)
+		}
+		notes = append(notes, &note)
+	}
+	return notes, rows.Err()
+}
+
+// insertNote stores a note and the cards its note type generates for it.
+func (db *DB) insertNote(note *Note) error {
+	return db.insertNotes([]*Note{note})
+}
+
+// insertNotes stores notes and their cards in one transaction. Every note is
+// checked before any is written, so either all are added or none is.
+func (db *DB) insertNotes(notes []*Note) error {
+	noteTypes := map[int]*NoteType{}
+	for _, note := range notes {
+		nt, ok := noteTypes[note.NoteTypeId]
+		if !ok {
+			var err error
+			if nt, err = db.getNoteType(note.NoteTypeId); err != nil {
+				return err
+			}
+			noteTypes[note.NoteTypeId] = nt
+		}
+		if _, err := nt.validateNote(note.Fields); err != nil {
+			return err
+		}
+	}
+
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, note := range notes {
+		fields, err := json.Marshal(note.Fields)
+		if err != nil {
+			return err
+		}
+		res, err := tx.Exec("INSERT INTO Notes(DeckId, NoteTypeId, Fields, Tags) VALUES (?, ?, ?, ?)", note.DeckId, note.NoteTypeId, string(fields), note.Tags)
+		if err != nil {
+			return err
+		}
+		id, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+		note.Id = int(id)
+
+		if err := db.syncNoteCards(tx, note, noteTypes[note.NoteTypeId]); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	if len(findHooks("card.added")) > 0 {
+		for _, note := range notes {
+			cards, err := db.getNoteCards(note.Id)
+			if err != nil {
+				log.Printf("Failed to read the cards of note id: %v for hooks: %v", note.Id, err)
+			}
+			for _, card := range cards {
+				record := db.newCardRecord(card)
+				db.fireHook("card.added", hookPayload{Card: &record})
+			}
+		}
+	}
+	return nil
+}
+
+func (db *DB) getNoteCards(noteId int) ([]BaseCard, error) {
+	rows, err := db.db.Query("SELECT "+cardColumns+" FROM Cards WHERE NoteId = ? ORDER BY Ord", noteId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cards := []BaseCard{}
+	for rows.Next() {
+		card, err := scanCard(rows)
+		if err != nil {
+			return nil, err
+		}
+		cards = append(cards, card)
+	}
+	return cards, rows.Err()
+}
+
+// updateNote stores new field values and re-renders the cards of the note.
+func (db *DB) updateNote(note *Note) error {
+	nt, err := db.getNoteType(note.NoteTypeId)
+	if err != nil {
+		return err
+	}
+	if _, err := nt.validateNote(note.Fields); err != nil {
+		return err
+	}
+	fields, err := json.Marshal(note.Fields)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("UPDATE Notes SET Fields = ?, Tags = ? WHERE Id = ?", string(fields), note.Tags, note.Id); err != nil {
+		return err
+	}
+	if err := db.syncNoteCards(tx, note, nt); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// syncNoteCards makes the cards of a note match what its templates render:
+// existing cards keep their scheduling, new templates add cards and cards
+// whose template no longer renders are removed.
+func (db *DB) syncNoteCards(tx *sql.Tx, note *Note, nt *NoteType) error {
+	rendered, err := nt.render(note.Fields)
+	if err != nil {
+		return err
+	}
+	if len(rendered) == 0 {
+		return fmt.Errorf("note id: %v would have no cards left", note.Id)
+	}
+
+	rows, err := tx.Query("SELECT Id, Ord FROM Cards WHERE NoteId = ?", note.Id)
+	if err != nil {
+		return err
+	}
+	existing := map[int]int{}
+	for rows.Next() {
+		var id, ord int
+		if err := rows.Scan(&id, &ord); err != nil {
+			rows.Close()
+			return err
+		}
+		existing[ord] = id
+	}
+	rows.Close()
+
+	for _, card := range rendered {
+		if id, ok := existing[card.Ord]; ok {
+			if _, err := tx.Exec("UPDATE Cards SET DeckId = ?, Front = ?, Back = ? WHERE Id = ?;", note.DeckId, card.Front, card.Back, id); err != nil {
+				return err
+			}
+			delete(existing, card.Ord)
+			continue
+		}
+		stmt := "INSERT INTO Cards(DeckId, Front, Back, Interval, EaseFactor, Repetition, ReviewDate, NoteId, Ord) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)"
+		if _, err := tx.Exec(stmt, note.DeckId, card.Front, card.Back, 0, db.getDeckFloatSetting(note.DeckId, "starting-ease"), 0, dueOn(db.today()), note.Id, card.Ord); err != nil {
+			return err
+		}
+	}
+	for _, id := range existing {
+		if _, err := tx.Exec("DELETE FROM Cards WHERE Id = ?;", id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateNotes creates the built-in note types and turns cards created
+// before note types existed into notes. Reversed pairs become a single
+// "Basic (and reversed card)" note.
+func (db *DB) migrateNotes() error {
+	for _, builtin := range builtinNoteTypes {
+		if _, err := db.getNoteTypeByName(builtin.Name); err == nil {
+			continue
+		}
+		nt := builtin
+		if err := db.saveNoteType(&nt); err != nil {
+			return err
+		}
+	}
+
+	basic, err := db.getNoteTypeByName(NoteTypeBasic)
+	if err != nil {
+		return err
+	}
+	reversed, err := db.getNoteTypeByName(NoteTypeReversed)
+	if err != nil {
+		return err
+	}
+
+	rows, err := db.db.Query("SELECT Id, DeckId, Front, Back, SiblingId FROM Cards WHERE NoteId = 0 ORDER BY Id")
+	if err != nil {
+		return err
+	}
+	type legacyCard struct {
+		Id, DeckId, SiblingId int
+		Front, Back           string
+	}
+	cards := map[int]legacyCard{}
+	ids := []int{}
+	for rows.Next() {
+		c := legacyCard{}
+		if err := rows.Scan(&c.Id, &c.DeckId, &c.Front, &c.Back, &c.SiblingId); err != nil {
+			rows.Close()
+			return err
+		}
+		cards[c.Id] = c
+		ids = append(ids, c.Id)
+	}
+	rows.Close()
+	if len(ids) == 0 {
+		return nil
+	}
+
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, id := range ids {
+		c, ok := cards[id]
+		if !ok {
+			continue
+		}
+		noteTypeId := basic.Id
+		sibling, hasSibling := cards[c.SiblingId]
+		if hasSibling {
+			noteTypeId = reversed.Id
+		}
+
+		fields, err := json.Marshal(map[string]string{"Front": c.Front, "Back": c.Back})
+		if err != nil {
+			return err
+		}
+		res, err := tx.Exec("INSERT INTO Notes(DeckId, NoteTypeId, Fields) VALUES (?, ?, ?)", c.DeckId, noteTypeId, string(fields))
+		if err != nil {
+			return err
+		}
+		noteId, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec("UPDATE Cards SET NoteId = ?, Ord = 0 WHERE Id = ?;", noteId, c.Id); err != nil {
+			return err
+		}
+		if hasSibling {
+			if _, err := tx.Exec("UPDATE Cards SET NoteId = ?, Ord = 1 WHERE Id = ?;", noteId, sibling.Id); err != nil {
+				return err
+			}
+			delete(cards, sibling.Id)
+		}
+		delete(cards, c.Id)
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateNote(t *testing.T) {
+	basic, reversed := builtinNoteTypes[0], builtinNoteTypes[1]
+	tests := []struct {
+		name   string
+		nt     NoteType
+		fields map[string]string
+		err    string
+		cards  int
+	}{
+		{"basic", basic, map[string]string{"Front": "hola", "Back": "hello"}, "", 1},
+		{"reversed", reversed, map[string]string{"Front": "hola", "Back": "hello"}, "", 2},
+		{"empty front", basic, map[string]string{"Front": "", "Back": "hello"}, "front cannot be empty", 0},
+		{"blank front", basic, map[string]string{"Front": "  ", "Back": "hello"}, "front cannot be empty", 0},
+		{"empty back", basic, map[string]string{"Front": "hola", "Back": ""}, "back of Card 1 card cannot be empty", 0},
+		{"reversed empty back", reversed, map[string]string{"Front": "hola", "Back": " "}, "back of Card 1 card cannot be empty", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rendered, err := tt.nt.validateNote(tt.fields)
+			if tt.err == "" && err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Fatalf("error %v, want %q", err, tt.err)
+			}
+			if len(rendered) != tt.cards {
+				t.Errorf("%d cards, want %d", len(rendered), tt.cards)
+			}
+		})
+	}
+}
+
+func TestEmptyNotesAreRejected(t *testing.T) {
+	db, _ := newTestDb(t, localTime(2024, 5, 10, 9, 0))
+	deckId := addTestDeck(t, db, "Spanish")
+	nt, err := db.getNoteTypeByName(NoteTypeBasic)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.insertNote(&Note{DeckId: deckId, NoteTypeId: nt.Id, Fields: map[string]string{"Front": "hola"}}); err == nil {
+		t.Error("added a note without a back")
+	}
+	card := addTestCard(t, db, deckId, "hola", "hello")
+	note, err := db.getNote(card.NoteId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	note.Fields["Back"] = ""
+	if err := db.updateNote(note); err == nil {
+		t.Error("emptied the back of a note")
+	}
+	if stored, _ := db.getCard(card.Id); stored.Back != "hello" {
+		t.Errorf("back is %q after the rejected edit, want hello", stored.Back)
+	}
+	if cards := db.getAllCards(); len(cards) != 1 {
+		t.Errorf("%d cards, want 1", len(cards))
+	}
+}
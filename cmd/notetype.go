@@ -0,0 +1,208 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// notetypeCmd represents the notetype command
+var notetypeCmd = &cobra.Command{
+	Use:   "notetype",
+	Short: "Manage note types and their card templates",
+	Long: `
+A note type defines the fields of a note and one or more card templates.
+Each template renders a card from the fields of a note using Go
+text/template syntax, e.g. {{.Word}}. Back templates can also use
+{{.FrontSide}}. A template whose front renders empty creates no card.
+	`,
+}
+
+var notetypeListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List note types",
+	Long: `
+List note types with their fields and card templates.
+
+With --output json or jsonl each note type is written as:
+
+  {"schema_version": 1, "id": 1, "name": "Basic", "builtin": true,
+   "fields": ["Front", "Back"], "templates": [{"name": "Card 1",
+   "front": "{{.Front}}", "back": "{{.Back}}"}]}
+	`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		db := openDb()
+		noteTypes, err := db.getNoteTypes()
+		if err != nil {
+			log.Fatal("Error querying for note types", err)
+		}
+		if !tableOutput() {
+			records := []noteTypeRecord{}
+			for _, nt := range noteTypes {
+				records = append(records, newNoteTypeRecord(nt))
+			}
+			printRecords(records, noteTypeOutputColumns)
+			return
+		}
+		for _, nt := range noteTypes {
+			name := nt.Name
+			if isBuiltinNoteType(name) {
+				name += " (built-in)"
+			}
+			fmt.Println(name)
+			fmt.Printf("  Fields: %s\n", strings.Join(nt.Fields, ", "))
+			for _, t := range nt.Templates {
+				fmt.Printf("  %s: %s → %s\n", t.Name, t.Front, t.Back)
+			}
+		}
+	},
+}
+
+var notetypeAddCmd = &cobra.Command{
+	Use:   "add <name>",
+	Short: "Add a note type",
+	Example: `  playita notetype add Vocab --fields Word,Reading,Meaning \
+    --front '{{.Word}}' --back '{{.Reading}} {{.Meaning}}' \
+    --front '{{.Meaning}}' --back '{{.Word}}'`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		nt := &NoteType{Name: strings.TrimSpace(args[0])}
+		nt.Fields, _ = cmd.Flags().GetStringSlice("fields")
+		nt.Templates = parseTemplateFlags(cmd)
+
+		db := openDb()
+		if _, err := db.getNoteTypeByName(nt.Name); err == nil {
+			log.Fatalf("Note type %s already exists\n", nt.Name)
+		}
+		if err := db.saveNoteType(nt); err != nil {
+			log.Fatal("Failed to add note type ", err)
+		}
+		fmt.Printf("Note type %s succesfully added!\n", nt.Name)
+	},
+}
+
+var notetypeEditCmd = &cobra.Command{
+	Use:   "edit <name>",
+	Short: "Edit a note type, re-rendering the cards of its notes",
+	Long: `
+Edit a note type. Passing --fields replaces the fields and passing --front
+and --back replaces all card templates. Cards of existing notes are
+re-rendered, keeping their scheduling; templates that were removed or now
+render an empty front delete their cards.
+	`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		db := openDb()
+		nt, err := db.getNoteTypeByName(args[0])
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if cmd.Flags().Changed("rename") {
+			if isBuiltinNoteType(nt.Name) {
+				log.Fatalf("Built-in note type %s cannot be renamed\n", nt.Name)
+			}
+			nt.Name, _ = cmd.Flags().GetString("rename")
+			nt.Name = strings.TrimSpace(nt.Name)
+		}
+		if cmd.Flags().Changed("fields") {
+			nt.Fields, _ = cmd.Flags().GetStringSlice("fields")
+		}
+		if cmd.Flags().Changed("front") || cmd.Flags().Changed("back") {
+			nt.Templates = parseTemplateFlags(cmd)
+		}
+
+		if err := db.saveNoteType(nt); err != nil {
+			log.Fatal("Failed to edit note type ", err)
+		}
+		fmt.Printf("Note type %s edited\n", nt.Name)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(notetypeCmd)
+	notetypeCmd.AddCommand(notetypeListCmd, notetypeAddCmd, notetypeEditCmd)
+
+	for _, cmd := range []*cobra.Command{notetypeAddCmd, notetypeEditCmd} {
+		cmd.Flags().StringSlice("fields", []string{}, "comma separated field names")
+		cmd.Flags().StringArray("front", []string{}, "front template of a card, repeat for each card")
+		cmd.Flags().StringArray("back", []string{}, "back template of a card, repeat for each card")
+		cmd.Flags().StringArray("card-name", []string{}, "name of a card template, defaults to Card N")
+	}
+	notetypeAddCmd.MarkFlagRequired("fields")
+	notetypeAddCmd.MarkFlagRequired("front")
+	notetypeAddCmd.MarkFlagRequired("back")
+	notetypeEditCmd.Flags().String("rename", "", "new name of the note type")
+}
+
+type noteTypeRecord struct {
+	schema
+	Id        int              `json:"id"`
+	Name      string           `json:"name"`
+	Builtin   bool             `json:"builtin"`
+	Fields    []string         `json:"fields"`
+	Templates []templateRecord `json:"templates"`
+}
+
+type templateRecord struct {
+	Name  string `json:"name"`
+	Front string `json:"front"`
+	Back  string `json:"back"`
+}
+
+func newNoteTypeRecord(nt NoteType) noteTypeRecord {
+	record := noteTypeRecord{
+		schema:    currentSchema,
+		Id:        nt.Id,
+		Name:      nt.Name,
+		Builtin:   isBuiltinNoteType(nt.Name),
+		Fields:    nt.Fields,
+		Templates: []templateRecord{},
+	}
+	for _, t := range nt.Templates {
+		record.Templates = append(record.Templates, templateRecord{Name: t.Name, Front: t.Front, Back: t.Back})
+	}
+	return record
+}
+
+var noteTypeOutputColumns = []outputColumn[noteTypeRecord]{
+	{"id", func(nt noteTypeRecord) string { return strconv.Itoa(nt.Id) }},
+	{"name", func(nt noteTypeRecord) string { return nt.Name }},
+	{"builtin", func(nt noteTypeRecord) string { return strconv.FormatBool(nt.Builtin) }},
+	{"fields", func(nt noteTypeRecord) string { return strings.Join(nt.Fields, ", ") }},
+	{"templates", func(nt noteTypeRecord) string {
+		names := []string{}
+		for _, t := range nt.Templates {
+			names = append(names, t.Name)
+		}
+		return strings.Join(names, ", ")
+	}},
+}
+
+// parseTemplateFlags pairs the n-th --front with the n-th --back and
+// --card-name.
+func parseTemplateFlags(cmd *cobra.Command) []CardTemplate {
+	fronts, _ := cmd.Flags().GetStringArray("front")
+	backs, _ := cmd.Flags().GetStringArray("back")
+	names, _ := cmd.Flags().GetStringArray("card-name")
+	if len(fronts) != len(backs) {
+		log.Fatalf("Got %d --front and %d --back templates, each card needs both\n", len(fronts), len(backs))
+	}
+	if len(names) > len(fronts) {
+		log.Fatalf("Got %d --card-name for %d cards\n", len(names), len(fronts))
+	}
+
+	templates := []CardTemplate{}
+	for i := range fronts {
+		name := fmt.Sprintf("Card %d", i+1)
+		if i < len(names) {
+			name = names[i]
+		}
+		templates = append(templates, CardTemplate{Name: name, Front: fronts[i], Back: backs[i]})
+	}
+	return templates
+}
@@ -0,0 +1,230 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// optimizeCmd represents the optimize command
+var optimizeCmd = &cobra.Command{
+	Use:   "optimize",
+	Short: "Fit the scheduler settings of a deck to its review history",
+	Long: `
+Fit the starting-ease and interval-modifier settings of a deck to the
+grades in its review history and print how well the current and the fitted
+settings predict them. Add --apply to save the fitted settings to the deck.
+
+The review history of every card is replayed with the candidate settings.
+A card is expected to be recalled with probability
+retention^(elapsed/interval), where interval is the one the settings would
+have given it, and the settings with the lowest log loss win. The search is
+random, so the same --seed always gives the same result.
+	`,
+	Example: `  playita optimize --deck Spanish
+  playita optimize --deck Spanish --retention 0.85 --apply`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		deckName, _ := cmd.Flags().GetString("deck")
+		retention, _ := cmd.Flags().GetFloat64("retention")
+		seed, _ := cmd.Flags().GetInt64("seed")
+		apply, _ := cmd.Flags().GetBool("apply")
+
+		if retention <= 0 || retention >= 1 {
+			log.Fatal("--retention must be between 0 and 1")
+		}
+
+		db := openDb()
+		deck, err := db.getDeckByName(deckName)
+		if err != nil {
+			log.Fatalf("Deck %q not found\n", deckName)
+		}
+
+		histories := db.getReviewHistories(deck.Id)
+		current := schedulerParams{
+			StartingEase:     db.getDeckFloatSetting(deck.Id, "starting-ease"),
+			IntervalModifier: db.getDeckFloatSetting(deck.Id, "interval-modifier"),
+		}
+		before := evaluateParams(histories, current, retention)
+		if before.Predictions < minOptimizeReviews {
+			fmt.Printf("Not enough review history to optimize, %d of %d reviews needed\n", before.Predictions, minOptimizeReviews)
+			return
+		}
+
+		fitted := fitParams(histories, retention, rand.New(rand.NewSource(seed)))
+		after := evaluateParams(histories, fitted, retention)
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "\tCURRENT\tFITTED")
+		fmt.Fprintf(w, "starting-ease\t%.2f\t%.2f\n", current.StartingEase, fitted.StartingEase)
+		fmt.Fprintf(w, "interval-modifier\t%.2f\t%.2f\n", current.IntervalModifier, fitted.IntervalModifier)
+		fmt.Fprintf(w, "log loss\t%.4f\t%.4f\n", before.LogLoss, after.LogLoss)
+		fmt.Fprintf(w, "RMSE\t%.4f\t%.4f\n", before.RMSE, after.RMSE)
+		w.Flush()
+		fmt.Printf("\nFitted on %d reviews of %d cards\n", after.Predictions, len(histories))
+
+		if !apply {
+			return
+		}
+		for key, value := range map[string]float64{"starting-ease": fitted.StartingEase, "interval-modifier": fitted.IntervalModifier} {
+			if err := db.setDeckSetting(deck.Id, key, strconv.FormatFloat(value, 'f', 2, 64)); err != nil {
+				log.Fatal("Failed to save setting ", err)
+			}
+		}
+		fmt.Printf("Saved the fitted settings to %s\n", deck.Name)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(optimizeCmd)
+
+	optimizeCmd.Flags().String("deck", "", "name of the deck to optimize")
+	optimizeCmd.Flags().Float64("retention", 0.9, "probability of recalling a card on the day it is due")
+	optimizeCmd.Flags().Int64("seed", 1, "seed of the random number generator")
+	optimizeCmd.Flags().Bool("apply", false, "save the fitted settings to the deck")
+	optimizeCmd.MarkFlagRequired("deck")
+}
+
+// minOptimizeReviews is the number of predictable reviews needed before
+// the settings are fitted.
+const minOptimizeReviews = 50
+
+// optimizeSamples is the number of random candidates tried before refining
+// the best one.
+const optimizeSamples = 200
+
+type schedulerParams struct {
+	StartingEase     float64
+	IntervalModifier float64
+}
+
+// reviewEvent is a grade given on a day counted from the Unix epoch.
+type reviewEvent struct {
+	Day   int
+	Grade int
+}
+
+type fitMetrics struct {
+	LogLoss     float64
+	RMSE        float64
+	Predictions int
+}
+
+// getReviewHistories returns the reviews of every card of a deck, oldest
+// first.
+func (db *DB) getReviewHistories(deckId int) [][]reviewEvent {
+	stmt := "SELECT r.CardId, r.ReviewedAt, r.Grade FROM Reviews r JOIN Cards c ON c.Id = r.CardId WHERE c.DeckId = ? ORDER BY r.CardId, datetime(r.ReviewedAt), r.Id"
+	rows, err := db.db.Query(stmt, deckId)
+	if err != nil {
+		log.Fatal("Error querying for reviews", err)
+	}
+	defer rows.Close()
+
+	epoch := time.Date(1970, 1, 1, 0, 0, 0, 0, time.Local)
+	histories := [][]reviewEvent{}
+	lastCardId := 0
+	for rows.Next() {
+		var cardId, grade int
+		var reviewedAt time.Time
+		if err := rows.Scan(&cardId, &reviewedAt, &grade); err != nil {
+			log.Printf("Error occurred whilst mapping reviews - error: %v", err)
+			continue
+		}
+		if cardId != lastCardId || len(histories) == 0 {
+			histories = append(histories, []reviewEvent{})
+			lastCardId = cardId
+		}
+		event := reviewEvent{Day: daysBetween(epoch, db.studyDay(reviewedAt)), Grade: grade}
+		histories[len(histories)-1] = append(histories[len(histories)-1], event)
+	}
+	return histories
+}
+
+// evaluateParams replays every history with params and scores the
+// predicted recall of each review on a later day than the one before it.
+// Histories are assumed to start when the card was new.
+func evaluateParams(histories [][]reviewEvent, params schedulerParams, retention float64) fitMetrics {
+	metrics := fitMetrics{}
+	squares := 0.0
+	for _, history := range histories {
+		card := BaseCard{EaseFactor: float32(params.StartingEase)}
+		for i, event := range history {
+			if i > 0 && event.Day > history[i-1].Day {
+				elapsed := float64(event.Day - history[i-1].Day)
+				p := math.Pow(retention, elapsed/float64(max(card.Interval, 1)))
+				p = math.Min(math.Max(p, 1e-4), 1-1e-4)
+				if event.Grade > 3 {
+					metrics.LogLoss -= math.Log(p)
+					squares += (1 - p) * (1 - p)
+				} else {
+					metrics.LogLoss -= math.Log(1 - p)
+					squares += p * p
+				}
+				metrics.Predictions++
+			}
+			card.grade(float32(event.Grade), params.IntervalModifier)
+		}
+	}
+	if metrics.Predictions > 0 {
+		metrics.LogLoss /= float64(metrics.Predictions)
+		metrics.RMSE = math.Sqrt(squares / float64(metrics.Predictions))
+	}
+	return metrics
+}
+
+// fitParams searches the allowed range of each setting for the lowest log
+// loss: random candidates first, then smaller and smaller steps around the
+// best one. Settings are rounded to two decimals as they are stored.
+func fitParams(histories [][]reviewEvent, retention float64, rng *rand.Rand) schedulerParams {
+	easeRange := settingFloatRanges["starting-ease"]
+	modifierRange := settingFloatRanges["interval-modifier"]
+	clamp := func(p schedulerParams) schedulerParams {
+		p.StartingEase = math.Round(math.Min(math.Max(p.StartingEase, easeRange[0]), easeRange[1])*100) / 100
+		p.IntervalModifier = math.Round(math.Min(math.Max(p.IntervalModifier, modifierRange[0]), modifierRange[1])*100) / 100
+		return p
+	}
+	loss := func(p schedulerParams) float64 {
+		return evaluateParams(histories, p, retention).LogLoss
+	}
+
+	best := clamp(schedulerParams{StartingEase: 2.5, IntervalModifier: 1})
+	bestLoss := loss(best)
+	for i := 0; i < optimizeSamples; i++ {
+		candidate := clamp(schedulerParams{
+			StartingEase:     easeRange[0] + rng.Float64()*(easeRange[1]-easeRange[0]),
+			IntervalModifier: modifierRange[0] + rng.Float64()*(modifierRange[1]-modifierRange[0]),
+		})
+		if l := loss(candidate); l < bestLoss {
+			best, bestLoss = candidate, l
+		}
+	}
+
+	easeStep := (easeRange[1] - easeRange[0]) / 10
+	modifierStep := (modifierRange[1] - modifierRange[0]) / 10
+	for easeStep >= 0.01 || modifierStep >= 0.01 {
+		improved := false
+		for _, candidate := range []schedulerParams{
+			{best.StartingEase + easeStep, best.IntervalModifier},
+			{best.StartingEase - easeStep, best.IntervalModifier},
+			{best.StartingEase, best.IntervalModifier + modifierStep},
+			{best.StartingEase, best.IntervalModifier - modifierStep},
+		} {
+			candidate = clamp(candidate)
+			if l := loss(candidate); l < bestLoss {
+				best, bestLoss, improved = candidate, l, true
+			}
+		}
+		if !improved {
+			easeStep /= 2
+			modifierStep /= 2
+		}
+	}
+	return best
+}
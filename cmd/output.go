@@ -0,0 +1,106 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"golang.org/x/exp/slices"
+)
+
+// outputSchemaVersion is written with every JSON record. It changes when a
+// field is renamed, removed or changes meaning; new fields may be added
+// without changing it.
+const outputSchemaVersion = 1
+
+var outputFormats = []string{"table", "json", "jsonl", "csv"}
+
+// outputFormat holds the global --output flag.
+var outputFormat string
+
+func init() {
+	rootCmd.PersistentFlags().StringVarP(&outputFormat, "output", "o", "table", "format of listings: "+strings.Join(outputFormats, ", "))
+}
+
+// schema is embedded in every record written as JSON.
+type schema struct {
+	SchemaVersion int `json:"schema_version"`
+}
+
+var currentSchema = schema{SchemaVersion: outputSchemaVersion}
+
+// outputColumn is a column of a table or CSV listing.
+type outputColumn[T any] struct {
+	Name  string
+	Value func(T) string
+}
+
+// printRecords writes records in the format selected with --output: a
+// table or CSV with the given columns, a JSON array or one JSON object per
+// line.
+func printRecords[T any](records []T, columns []outputColumn[T]) {
+	switch outputFormat {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(records); err != nil {
+			log.Fatal(err)
+		}
+	case "jsonl":
+		enc := json.NewEncoder(os.Stdout)
+		for _, record := range records {
+			if err := enc.Encode(record); err != nil {
+				log.Fatal(err)
+			}
+		}
+	case "csv":
+		w := csv.NewWriter(os.Stdout)
+		header := []string{}
+		for _, column := range columns {
+			header = append(header, column.Name)
+		}
+		w.Write(header)
+		for _, record := range records {
+			row := []string{}
+			for _, column := range columns {
+				row = append(row, column.Value(record))
+			}
+			w.Write(row)
+		}
+		w.Flush()
+		if err := w.Error(); err != nil {
+			log.Fatal(err)
+		}
+	default:
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		header := []string{}
+		for _, column := range columns {
+			header = append(header, strings.ToUpper(strings.ReplaceAll(column.Name, "_", " ")))
+		}
+		fmt.Fprintln(w, strings.Join(header, "\t"))
+		for _, record := range records {
+			row := []string{}
+			for _, column := range columns {
+				row = append(row, column.Value(record))
+			}
+			fmt.Fprintln(w, strings.Join(row, "\t"))
+		}
+		w.Flush()
+	}
+}
+
+// tableOutput reports whether listings are meant to be read by a person,
+// who can be shown messages around them.
+func tableOutput() bool {
+	return outputFormat == "table"
+}
+
+func validateOutputFormat() {
+	if !slices.Contains(outputFormats, outputFormat) {
+		log.Fatalf("Unknown output format %q, expected one of: %s\n", outputFormat, strings.Join(outputFormats, ", "))
+	}
+}
@@ -0,0 +1,185 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// SessionFilter selects the cards of a review session spanning one or more
+// decks.
+type SessionFilter struct {
+	DeckIds []int
+	// DueWithin includes cards due in the next DueWithin days.
+	DueWithin int
+	// FailedToday selects cards graded 3 or lower today instead of due cards.
+	FailedToday bool
+	// NotDue includes cards that aren't due yet.
+	NotDue bool
+	Tag    string
+	Random bool
+	Limit  int
+}
+
+// reviewCmd represents the review command
+var reviewCmd = &cobra.Command{
+	Use:   "review",
+	Short: "Review cards from several decks in one session",
+	Long: `
+Review the due cards of every deck (--all) or of the listed decks
+(--deck a,b) in one interleaved session.
+
+Custom study sessions narrow the cards down further: --ahead N includes
+cards due in the next N days, --failed-today picks the cards failed today,
+--tag limits the session to notes with a tag and --random with --limit N
+draws N random cards. Without --ahead, --tag and --random pick from every
+card whether it is due or not. Add --no-reschedule to study without
+changing when cards are due.
+
+Once every card is answered a summary of the session is shown. With
+--output json or jsonl it is written as:
+
+  {"schema_version": 1, "cards": 12, "answers": 15, "new": 3, "review": 9,
+   "grades": {"1": 1, "2": 0, "3": 2, "4": 5, "5": 7},
+   "correct_percent": 80, "seconds": 245.3, "seconds_per_card": 16.3,
+   "failed": [{"id": 4, "front": "adios"}], "due_tomorrow": 20,
+   "preview": false}
+	`,
+	Example: `  playita review --all
+  playita review --deck Spanish,German --ahead 3
+  playita review --all --failed-today --no-reschedule
+  playita review --all --tag verbs --random --limit 50`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		all, _ := cmd.Flags().GetBool("all")
+		deckNames, _ := cmd.Flags().GetStringSlice("deck")
+		if all == (len(deckNames) > 0) {
+			log.Fatal("pass either --all or --deck")
+		}
+
+		filter := SessionFilter{}
+		filter.DueWithin, _ = cmd.Flags().GetInt("ahead")
+		filter.FailedToday, _ = cmd.Flags().GetBool("failed-today")
+		filter.Tag, _ = cmd.Flags().GetString("tag")
+		filter.Random, _ = cmd.Flags().GetBool("random")
+		filter.Limit, _ = cmd.Flags().GetInt("limit")
+		filter.NotDue = (filter.Tag != "" || filter.Random) && !cmd.Flags().Changed("ahead")
+		noReschedule, _ := cmd.Flags().GetBool("no-reschedule")
+
+		db := openDb()
+		for _, name := range deckNames {
+			deck, err := db.getDeckByName(strings.TrimSpace(name))
+			if err != nil {
+				log.Fatalf("Deck %q not found\n", name)
+			}
+			filter.DeckIds = append(filter.DeckIds, deck.Id)
+		}
+
+		deck := db.getSessionCards(filter)
+		if len(deck.Cards) == 0 {
+			fmt.Print("No cards to review 🥳 \n ")
+			return
+		}
+		deck.Preview = noReschedule
+		deck.review(db)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(reviewCmd)
+
+	reviewCmd.Flags().Bool("all", false, "review cards from every deck")
+	reviewCmd.Flags().StringSlice("deck", []string{}, "comma separated names of the decks to review")
+	reviewCmd.Flags().Int("ahead", 0, "include cards due in the next N days")
+	reviewCmd.Flags().Bool("failed-today", false, "review the cards failed today")
+	reviewCmd.Flags().String("tag", "", "only review notes with this tag")
+	reviewCmd.Flags().Bool("random", false, "draw cards in random order")
+	reviewCmd.Flags().Int("limit", 0, "maximum number of cards in the session")
+	reviewCmd.Flags().Bool("no-reschedule", false, "do not change the scheduling of reviewed cards")
+	reviewCmd.MarkFlagsMutuallyExclusive("all", "deck")
+	reviewCmd.MarkFlagsMutuallyExclusive("ahead", "failed-today")
+}
+
+// getSessionCards returns the cards matching filter. Unless the session is
+// random, cards of different decks alternate.
+func (db *DB) getSessionCards(filter SessionFilter) *ReviewDeck {
+	conditions := []string{cardAvailable}
+	args := []any{db.now().UTC()}
+
+	if len(filter.DeckIds) > 0 {
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(filter.DeckIds)), ", ")
+		conditions = append(conditions, "DeckId IN ("+placeholders+")")
+		for _, id := range filter.DeckIds {
+			args = append(args, id)
+		}
+	}
+	if filter.FailedToday {
+		conditions = append(conditions, "Id IN (SELECT CardId FROM Reviews WHERE Grade <= 3 AND datetime(ReviewedAt) >= datetime(?))")
+		args = append(args, db.dayStart(db.today()))
+	} else if !filter.NotDue {
+		conditions = append(conditions, "datetime(ReviewDate) < datetime(?)")
+		args = append(args, db.dueCutoff(filter.DueWithin))
+	}
+	if filter.Tag != "" {
+		conditions = append(conditions, "NoteId IN (SELECT Id FROM Notes WHERE "+hasTag+")")
+		args = append(args, tagPattern(filter.Tag))
+	}
+
+	stmt := "SELECT " + cardColumns + " FROM Cards WHERE " + strings.Join(conditions, " AND ")
+	if filter.Random {
+		stmt += " ORDER BY RANDOM()"
+	} else {
+		stmt += " ORDER BY ReviewDate"
+	}
+	if filter.Limit > 0 {
+		stmt += " LIMIT ?"
+		args = append(args, filter.Limit)
+	}
+
+	rows, err := db.db.Query(stmt, args...)
+	if err != nil {
+		log.Fatal("Error querying for cards", err)
+	}
+	defer rows.Close()
+
+	reviewDeck := ReviewDeck{
+		Cards: []BaseCard{},
+	}
+	for rows.Next() {
+		i, err := scanCard(rows)
+		if err != nil {
+			log.Printf("Error occurred whilst mapping cards Id: %v - error: %v", &i.Id, err)
+		}
+		reviewDeck.Cards = append(reviewDeck.Cards, i)
+	}
+	if !filter.Random {
+		reviewDeck.Cards = interleaveByDeck(reviewDeck.Cards)
+	}
+	return &reviewDeck
+}
+
+// interleaveByDeck takes one card from each deck in turn, keeping the order
+// of the cards within a deck.
+func interleaveByDeck(cards []BaseCard) []BaseCard {
+	deckOrder := []int{}
+	byDeck := map[int][]BaseCard{}
+	for _, card := range cards {
+		if _, ok := byDeck[card.DeckId]; !ok {
+			deckOrder = append(deckOrder, card.DeckId)
+		}
+		byDeck[card.DeckId] = append(byDeck[card.DeckId], card)
+	}
+
+	interleaved := make([]BaseCard, 0, len(cards))
+	for len(interleaved) < len(cards) {
+		for _, deckId := range deckOrder {
+			if queue := byDeck[deckId]; len(queue) > 0 {
+				interleaved = append(interleaved, queue[0])
+				byDeck[deckId] = queue[1:]
+			}
+		}
+	}
+	return interleaved
+}
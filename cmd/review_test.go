@@ -0,0 +1,73 @@
+package cmd
+
+import "testing"
+
+func TestSessionTagFilter(t *testing.T) {
+	db, _ := newTestDb(t, localTime(2024, 5, 10, 9, 0))
+	deckId := addTestDeck(t, db, "Deck")
+	tagged := map[string]int{}
+	for _, tag := range []string{"a_b", "axb", "100%", "1000", `back\slash`, "verbs"} {
+		card := addTestCard(t, db, deckId, tag, "back")
+		if err := db.addNoteTag(card.NoteId, tag); err != nil {
+			t.Fatal(err)
+		}
+		tagged[tag] = card.Id
+	}
+
+	tests := []struct {
+		tag  string
+		want []int
+	}{
+		{"a_b", []int{tagged["a_b"]}},
+		{"100%", []int{tagged["100%"]}},
+		{`back\slash`, []int{tagged[`back\slash`]}},
+		{"verbs", []int{tagged["verbs"]}},
+		{"verb", []int{}},
+		{"%", []int{}},
+		{"_", []int{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.tag, func(t *testing.T) {
+			got := []int{}
+			for _, card := range db.getSessionCards(SessionFilter{Tag: tt.tag}).Cards {
+				got = append(got, card.Id)
+			}
+			if !equalInts(got, tt.want) {
+				t.Errorf("cards tagged %q: %v, want %v", tt.tag, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSessionNotDue(t *testing.T) {
+	db, _ := newTestDb(t, localTime(2024, 5, 10, 9, 0))
+	deckId := addTestDeck(t, db, "Deck")
+	due := addTestCard(t, db, deckId, "due", "back")
+	for i := 0; i < 5; i++ {
+		card := addTestCard(t, db, deckId, "later", "back")
+		setSchedule(t, db, card, 2, 10, 2.5, dueOn(localTime(2024, 5, 20+i, 0, 0)))
+		if err := db.addNoteTag(card.NoteId, "verbs"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.addNoteTag(due.NoteId, "verbs"); err != nil {
+		t.Fatal(err)
+	}
+
+	if cards := db.getSessionCards(SessionFilter{Tag: "verbs", Random: true}).Cards; len(cards) != 1 || cards[0].Id != due.Id {
+		t.Errorf("due cards tagged verbs: %v, want only %d", cards, due.Id)
+	}
+	cards := db.getSessionCards(SessionFilter{Tag: "verbs", Random: true, Limit: 4, NotDue: true}).Cards
+	if len(cards) != 4 {
+		t.Fatalf("drew %d cards, want 4", len(cards))
+	}
+	notDue := 0
+	for _, card := range cards {
+		if card.Id != due.Id {
+			notDue++
+		}
+	}
+	if notDue < 3 {
+		t.Errorf("drew %d cards that aren't due, want at least 3", notDue)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"log"
 	"math"
+	"math/rand"
 	"os"
 	"os/exec"
 	"strconv"
@@ -25,22 +26,32 @@ var rootCmd = &cobra.Command{
 Space repetition in the terminal.
 For details on how the program works please visit: github.com/carlosperez-dev/playita_cli
 	`,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		validateOutputFormat()
+	},
 	Run: func(cmd *cobra.Command, args []string) {
+		if deckName, _ := cmd.Flags().GetString("deck"); deckName != "" {
+			db := openDb()
+			deck := db.getCardsToReview(db.mustGetDeck(deckName).Id)
+			if len(deck.Cards) == 0 {
+				fmt.Print("No cards to review 🥳 \n ")
+				return
+			}
+			deck.review(db)
+			return
+		}
+
 		menu := []string{
 			"Review",
 			"Add Card",
+			"Edit Card",
 			"Create Deck",
 			"Delete Card",
 			"Delete Deck",
 			"Quit",
 		}
 
-		file := "playita"
-
-		db, err := newDb(file)
-		if err != nil {
-			log.Fatal("Error when starting db", err)
-		}
+		db := openDb()
 
 		for {
 			OpenMenu(menu, db)
@@ -48,9 +59,21 @@ For details on how the program works please visit: github.com/carlosperez-dev/pl
 	},
 }
 
+func openDb() *DB {
+	db, err := newDb(dbFile, clockFromFlags())
+	if err != nil {
+		log.Fatal("Error when starting db", err)
+	}
+	if err := db.autoSnapshot(); err != nil {
+		log.Printf("Failed to back up the database: %v", err)
+	}
+	return db
+}
+
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
+	registerCompletions(rootCmd)
 	err := rootCmd.Execute()
 	if err != nil {
 		os.Exit(1)
@@ -58,11 +81,36 @@ func Execute() {
 }
 
 func init() {
-	rootCmd.Flags()
+	rootCmd.Flags().String("deck", "", "review the due cards of this deck instead of opening the menu")
+}
+
+const dbFile = "playita"
+
+// cardTypeFlags maps the shorthands accepted by the --type flag to note types.
+var cardTypeFlags = map[string]string{
+	"basic":    NoteTypeBasic,
+	"reversed": NoteTypeReversed,
 }
 
+// cardColumns lists the Cards columns in the order expected by scanCard.
+const cardColumns = "Id, DeckId, Front, Back, Interval, EaseFactor, Repetition, ReviewDate, NoteId, Ord, BuriedUntil, Suspended, Flag, Lapses"
+
+// cardAvailable excludes suspended cards and cards buried until later.
+// It takes the current time as its only argument.
+const cardAvailable = "Suspended = 0 AND (BuriedUntil IS NULL OR datetime(BuriedUntil) <= datetime(?))"
+
+// Keys accepted instead of a score while reviewing.
+const (
+	suspendKey = "s"
+	buryKey    = "b"
+	flagKey    = "f"
+)
+
 type DB struct {
-	db *sql.DB
+	db    *sql.DB
+	clock Clock
+	// rand draws interval fuzz; tests can replace it with a seeded source.
+	rand *rand.Rand
 }
 
 type BaseCard struct {
@@ -74,6 +122,16 @@ type BaseCard struct {
 	EaseFactor float32
 	Repetition int
 	ReviewDate time.Time
+	// NoteId and Ord identify the note and the card template the card was
+	// rendered from. Front and Back hold the rendered template.
+	NoteId      int
+	Ord         int
+	BuriedUntil time.Time
+	Suspended   bool
+	// Flag is an index into flagNames, 0 when the card is not flagged.
+	Flag int
+	// Lapses counts the days on which the card was failed.
+	Lapses int
 }
 
 type BaseDeck struct {
@@ -85,10 +143,15 @@ type BaseDeckWithCardCount struct {
 	Id            int
 	Name          string
 	CardsToReview int
+	Cards         int
 }
 
 type ReviewDeck struct {
 	Cards []BaseCard
+	// Preview sessions show cards without changing their scheduling.
+	Preview bool
+	// Summary collects the grades given during the session.
+	Summary sessionSummary
 }
 
 func OpenMenu(menu []string, db *DB) {
@@ -100,12 +163,14 @@ func OpenMenu(menu []string, db *DB) {
 	} else if menuOptions == menu[1] {
 		AddCardHandler(db, creationOptions)
 	} else if menuOptions == menu[2] {
-		AddDeckHandler(db, creationOptions)
+		EditCardHandler(db)
 	} else if menuOptions == menu[3] {
-		DeleteCardHandler(db, deleteOptions)
+		AddDeckHandler(db, creationOptions)
 	} else if menuOptions == menu[4] {
-		DeleteDeckHandler(db, deleteOptions)
+		DeleteCardHandler(db, deleteOptions)
 	} else if menuOptions == menu[5] {
+		DeleteDeckHandler(db, deleteOptions)
+	} else if menuOptions == menu[6] {
 		os.Exit(0)
 	}
 }
@@ -120,6 +185,49 @@ func DeleteCardHandler(db *DB, deleteOptions []string) {
 		fmt.Print("No cards in deck \n ")
 		return
 	}
+	i := selectCard(deck)
+	confirmCardDelete(db, deck.Cards[i].Id)
+
+}
+
+// EditCardHandler opens the note of a card in the user's editor and
+// re-renders every card of the note.
+func EditCardHandler(db *DB) {
+	deckId := getDeckOfCard(db)
+	deck := getCardsFromDeck(db, deckId)
+
+	if len(deck.Cards) == 0 {
+		clearConsole()
+		fmt.Print("No cards in deck \n ")
+		return
+	}
+	card := deck.Cards[selectCard(deck)]
+
+	note, err := db.getNote(card.NoteId)
+	if err != nil {
+		fmt.Println("ERROR: ", err)
+		return
+	}
+	nt, err := db.getNoteType(note.NoteTypeId)
+	if err != nil {
+		fmt.Println("ERROR: ", err)
+		return
+	}
+	fields, err := editNoteFields(nt, note.Fields)
+	if err != nil {
+		fmt.Println("ERROR: ", err)
+		return
+	}
+	note.Fields = fields
+	if err := db.updateNote(note); err != nil {
+		fmt.Printf("Failed to edit card id: %v with error: %v\n", card.Id, err)
+		return
+	}
+	clearConsole()
+	fmt.Println("Card edited")
+}
+
+func selectCard(deck *ReviewDeck) int {
 	templates := &promptui.SelectTemplates{
 		Active:   "▸ {{.Front}} {{.Back}}",
 		Inactive: "  {{.Front | faint}} {{.Back | faint}}",
@@ -148,12 +256,29 @@ func DeleteCardHandler(db *DB, deleteOptions []string) {
 	if err != nil {
 		log.Fatalf("Prompt failed %v\n", err)
 	}
-	confirmCardDelete(db, deck.Cards[i].Id)
+	return i
+}
 
+func (db *DB) getAllCards() []BaseCard {
+	rows, err := db.db.Query("SELECT " + cardColumns + " FROM Cards ORDER BY DeckId, Id")
+	if err != nil {
+		log.Fatal("Error querying for cards", err)
+	}
+	defer rows.Close()
+
+	cards := []BaseCard{}
+	for rows.Next() {
+		i, err := scanCard(rows)
+		if err != nil {
+			log.Printf("Error occurred whilst mapping cards Id: %v - error: %v", &i.Id, err)
+		}
+		cards = append(cards, i)
+	}
+	return cards
 }
 
 func getCardsFromDeck(db *DB, deckId int) *ReviewDeck {
-	stmt := "SELECT * FROM Cards WHERE DeckId = ?"
+	stmt := "SELECT " + cardColumns + " FROM Cards WHERE DeckId = ?"
 	rows, err := db.db.Query(stmt, deckId)
 	if err != nil {
 		log.Fatal("Error querying for cards", err)
@@ -165,8 +290,7 @@ func getCardsFromDeck(db *DB, deckId int) *ReviewDeck {
 		Cards: []BaseCard{},
 	}
 	for rows.Next() {
-		i := BaseCard{}
-		err = rows.Scan(&i.Id, &i.DeckId, &i.Front, &i.Back, &i.Interval, &i.EaseFactor, &i.Repetition, &i.ReviewDate)
+		i, err := scanCard(rows)
 		if err != nil {
 			log.Printf("Error occurred whilst mapping cards Id: %v - error: %v", &i.Id, err)
 		}
@@ -175,15 +299,64 @@ func getCardsFromDeck(db *DB, deckId int) *ReviewDeck {
 	return &reviewDeck
 }
 
-func deleteCard(db *DB, cardId int) {
-	_, err := db.db.Exec("DELETE FROM Cards WHERE Id = ?;", cardId)
+func scanCard(rows *sql.Rows) (BaseCard, error) {
+	i := BaseCard{}
+	var buriedUntil sql.NullTime
+	err := rows.Scan(&i.Id, &i.DeckId, &i.Front, &i.Back, &i.Interval, &i.EaseFactor, &i.Repetition, &i.ReviewDate, &i.NoteId, &i.Ord, &buriedUntil, &i.Suspended, &i.Flag, &i.Lapses)
+	i.BuriedUntil = buriedUntil.Time
+	return i, err
+}
+
+func (db *DB) getCard(cardId int) (*BaseCard, error) {
+	rows, err := db.db.Query("SELECT "+cardColumns+" FROM Cards WHERE Id = ?", cardId)
 	if err != nil {
-		fmt.Printf("Failed to delete deck id: %v with error: %v", cardId, err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, fmt.Errorf("card id: %v not found", cardId)
+	}
+	card, err := scanCard(rows)
+	if err != nil {
+		return nil, err
+	}
+	return &card, nil
+}
+
+func deleteCard(db *DB, cardId int) {
+	if err := db.deleteNoteOfCard(cardId); err != nil {
+		fmt.Printf("Failed to delete card id: %v with error: %v", cardId, err)
 		return
 	}
 	fmt.Println("Card Deleted")
 }
 
+// deleteNoteOfCard deletes a card together with its note and every other
+// card rendered from that note.
+func (db *DB) deleteNoteOfCard(cardId int) error {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var noteId int
+	if err := tx.QueryRow("SELECT NoteId FROM Cards WHERE Id = ?", cardId).Scan(&noteId); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM Reviews WHERE CardId IN (SELECT Id FROM Cards WHERE Id = ? OR (NoteId != 0 AND NoteId = ?));", cardId, noteId); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM Cards WHERE Id = ? OR (NoteId != 0 AND NoteId = ?);", cardId, noteId); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM Notes WHERE Id = ?;", noteId); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
 func DeleteDeckHandler(db *DB, deletionOptions []string) {
 	decks := db.getExistingDecks()
 	if len(decks) == 0 {
@@ -289,12 +462,45 @@ func confirmCardDelete(db *DB, cardId int) {
 }
 
 func deleteDeck(db *DB, deckId int) {
-	_, err := db.db.Exec("DELETE FROM Decks WHERE Id = ?;", deckId)
+	deck := hookDeck{Id: deckId}
+	err := db.db.QueryRow("SELECT Name FROM Decks WHERE Id = ?;", deckId).Scan(&deck.Name)
+	if err == nil {
+		err = db.deleteDeck(deckId)
+	}
 	if err != nil {
 		fmt.Printf("Failed to delete deck id: %v with error: %v", deckId, err)
 		return
 	}
 	fmt.Println("Deck Deleted")
+	db.fireHook("deck.deleted", hookPayload{Deck: &deck})
+}
+
+// deleteDeck deletes a deck with its notes, every card of those notes or in
+// the deck and their reviews.
+func (db *DB) deleteDeck(deckId int) error {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	cards := "SELECT Id FROM Cards WHERE DeckId = ? OR NoteId IN (SELECT Id FROM Notes WHERE DeckId = ?)"
+	stmts := []struct {
+		stmt string
+		args []any
+	}{
+		{"DELETE FROM Reviews WHERE CardId IN (" + cards + ");", []any{deckId, deckId}},
+		{"DELETE FROM Cards WHERE Id IN (" + cards + ");", []any{deckId, deckId}},
+		{"DELETE FROM Notes WHERE DeckId = ?;", []any{deckId}},
+		{"DELETE FROM DeckSettings WHERE DeckId = ?;", []any{deckId}},
+		{"DELETE FROM Decks WHERE Id = ?;", []any{deckId}},
+	}
+	for _, s := range stmts {
+		if _, err := tx.Exec(s.stmt, s.args...); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
 }
 
 func ReviewHandler(db *DB) {
@@ -324,14 +530,18 @@ func postAddDeckMenu(db *DB, creationOptions []string) {
 }
 
 func AddCardHandler(db *DB, creationOptions []string, params ...int) {
-	var card *BaseCard
+	var note *Note
 	if len(params) == 0 {
-		card = createCard(db)
+		note = createNote(db)
 	} else if len(params) == 1 {
-		card = createCard(db, params[0])
+		note = createNote(db, params[0])
 	}
-	db.addNewCard(card)
-	postAddCardMenu(db, creationOptions, card.DeckId)
+	if note == nil {
+		fmt.Println("Add cancelled")
+		return
+	}
+	db.addNewNote(note)
+	postAddCardMenu(db, creationOptions, note.DeckId)
 }
 
 func postAddCardMenu(db *DB, creationOptions []string, deckId int) {
@@ -343,7 +553,7 @@ func postAddCardMenu(db *DB, creationOptions []string, deckId int) {
 	}
 }
 
-func newDb(file string) (*DB, error) {
+func newDb(file string, clock Clock) (*DB, error) {
 	file = strings.TrimSpace(file)
 	if file == "" {
 		return nil, errors.New("cannot instantiate a db with empty/whitespace name")
@@ -358,9 +568,98 @@ func newDb(file string) (*DB, error) {
 		return nil, err
 	}
 
-	return &DB{
-		db: db,
-	}, nil
+	d := &DB{
+		db:    db,
+		clock: clock,
+		rand:  rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	if err := d.migrate(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// migrate brings databases created by older versions up to the current schema.
+func (db *DB) migrate() error {
+	create := "CREATE TABLE IF NOT EXISTS [Settings] ( Key TEXT NOT NULL PRIMARY KEY, Value TEXT NOT NULL);"
+	if _, err := db.db.Exec(create); err != nil {
+		return err
+	}
+	create = "CREATE TABLE IF NOT EXISTS [NoteTypes] ( Id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT, Name TEXT NOT NULL UNIQUE, Fields TEXT NOT NULL); CREATE TABLE IF NOT EXISTS [CardTemplates] ( NoteTypeId INTEGER NOT NULL, Ord INTEGER NOT NULL, Name TEXT NOT NULL, Front TEXT NOT NULL, Back TEXT NOT NULL, PRIMARY KEY(NoteTypeId, Ord), FOREIGN KEY(NoteTypeId) REFERENCES NoteTypes(Id)); CREATE TABLE IF NOT EXISTS [Notes] ( Id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT, DeckId INTEGER NOT NULL, NoteTypeId INTEGER NOT NULL, Fields TEXT NOT NULL, FOREIGN KEY(DeckId) REFERENCES Decks(Id), FOREIGN KEY(NoteTypeId) REFERENCES NoteTypes(Id));"
+	if _, err := db.db.Exec(create); err != nil {
+		return err
+	}
+	// SiblingId linked reversed pairs before note types existed, it is only
+	// read when converting those pairs into notes.
+	if err := db.addColumnIfMissing("Cards", "SiblingId", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := db.addColumnIfMissing("Cards", "BuriedUntil", "DATETIME"); err != nil {
+		return err
+	}
+	if err := db.addColumnIfMissing("Cards", "NoteId", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := db.addColumnIfMissing("Cards", "Ord", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := db.addColumnIfMissing("Notes", "Tags", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := db.addColumnIfMissing("Cards", "Suspended", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := db.addColumnIfMissing("Cards", "Flag", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := db.addColumnIfMissing("Cards", "Lapses", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	create = "CREATE TABLE IF NOT EXISTS [DeckSettings] ( DeckId INTEGER NOT NULL, Key TEXT NOT NULL, Value TEXT NOT NULL, PRIMARY KEY(DeckId, Key), FOREIGN KEY(DeckId) REFERENCES Decks(Id));"
+	if _, err := db.db.Exec(create); err != nil {
+		return err
+	}
+	create = "CREATE TABLE IF NOT EXISTS [Reviews] ( Id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT, CardId INTEGER NOT NULL, ReviewedAt DATETIME NOT NULL, Grade INTEGER NOT NULL, Interval INTEGER NOT NULL, EaseFactor DECIMAL(10,8) NOT NULL, FOREIGN KEY(CardId) REFERENCES Cards(Id));"
+	if _, err := db.db.Exec(create); err != nil {
+		return err
+	}
+	// Duration is the time taken to answer in milliseconds, 0 for reviews
+	// that weren't timed.
+	if err := db.addColumnIfMissing("Reviews", "Duration", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := db.migrateNotes(); err != nil {
+		return err
+	}
+	if err := db.migrateStudyDays(); err != nil {
+		return err
+	}
+	return db.migrateSync()
+}
+
+func (db *DB) addColumnIfMissing(table string, column string, definition string) error {
+	rows, err := db.db.Query("SELECT name FROM pragma_table_info(?)", table)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		if strings.EqualFold(name, column) {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	_, err = db.db.Exec(fmt.Sprintf("ALTER TABLE [%s] ADD COLUMN %s %s", table, column, definition))
+	return err
 }
 
 func (db *DB) addNewDeck(deck *BaseDeck) {
@@ -371,15 +670,24 @@ func (db *DB) addNewDeck(deck *BaseDeck) {
 	fmt.Printf("Deck %s succesfully added!", deck.Name)
 }
 
-func (db *DB) addNewCard(card *BaseCard) {
-	stmt := "INSERT INTO Cards(DeckId, Front, Back, Interval, EaseFactor, Repetition, ReviewDate) VALUES (?, ?, ?, ?, ?, ?, ?)"
-	if _, err := db.db.Exec(stmt, card.DeckId, card.Front, card.Back, 0, 2.5, 0, time.Now()); err != nil {
+func (db *DB) addNewNote(note *Note) {
+	if err := db.insertNote(note); err != nil {
 		log.Fatal("Failed to execute INSERT", err)
 	}
 	fmt.Printf("Card succesfully added!")
 }
 
-func createCard(db *DB, params ...int) *BaseCard {
+// burySiblings hides the other cards of the note of card until the start
+// of the next day.
+func (db *DB) burySiblings(card *BaseCard) {
+	buriedUntil := db.dayStart(db.today().AddDate(0, 0, 1))
+	_, err := db.db.Exec("UPDATE Cards SET BuriedUntil = ? WHERE NoteId = ? AND Id != ?;", buriedUntil, card.NoteId, card.Id)
+	if err != nil {
+		fmt.Printf("Failed to bury siblings of card Id: %v with error: %v", card.Id, err)
+	}
+}
+
+func createNote(db *DB, params ...int) *Note {
 	var deckId int
 	if len(params) == 0 {
 		deckId = getDeckOfCard(db)
@@ -392,18 +700,36 @@ func createCard(db *DB, params ...int) *BaseCard {
 	} else if len(params) == 1 {
 		deckId = params[0]
 	}
-	front := getFrontOfCard()
-	back := getBackOfCard()
 
-	return &BaseCard{
+	noteTypes, err := db.getNoteTypes()
+	if err != nil {
+		log.Fatal("Error querying for note types", err)
+	}
+	names := []string{}
+	for _, nt := range noteTypes {
+		names = append(names, nt.Name)
+	}
+	name := selectOption(names, "Note type")
+	nt := noteTypes[slices.Index(names, name)]
+
+	fields := map[string]string{}
+	if db.getBoolSetting("use-editor") {
+		fields, err = editNoteFields(&nt, fields)
+		if err != nil {
+			fmt.Println("ERROR: ", err)
+			return nil
+		}
+	} else {
+		for i, field := range nt.Fields {
+			fields[field] = getFieldOfNote(field, i == 0)
+		}
+	}
+
+	return &Note{
 		Id:         0,
 		DeckId:     deckId,
-		Front:      front,
-		Back:       back,
-		Interval:   0,
-		EaseFactor: 0,
-		Repetition: 0,
-		ReviewDate: time.Now(),
+		NoteTypeId: nt.Id,
+		Fields:     fields,
 	}
 
 }
@@ -506,8 +832,8 @@ func (db *DB) getExistingDecks() []BaseDeck {
 }
 
 func (db *DB) getExistingDecksWithCardCount() []BaseDeckWithCardCount {
-	stmt := "SELECT Decks.Id, Decks.Name, COUNT(Cards.Id) AS CardCount FROM Decks JOIN Cards ON Cards.DeckId = Decks.Id WHERE datetime(Cards.ReviewDate) <= datetime('now') GROUP BY Decks.Id ORDER BY Decks.Id;"
-	rows, err := db.db.Query(stmt)
+	stmt := "SELECT Decks.Id, Decks.Name, COUNT(Cards.Id) AS CardCount FROM Decks JOIN Cards ON Cards.DeckId = Decks.Id WHERE datetime(Cards.ReviewDate) < datetime(?) AND " + cardAvailable + " GROUP BY Decks.Id ORDER BY Decks.Id;"
+	rows, err := db.db.Query(stmt, db.dueCutoff(0), db.now().UTC())
 	if err != nil {
 		log.Fatal("Error querying for cards", err)
 	}
@@ -533,38 +859,16 @@ func createDeck() *BaseDeck {
 	}
 }
 
-func getBackOfCard() string {
-	validate := func(input string) error {
-		if len(input) == 0 {
-			return errors.New("back of card cannot be empty")
-		}
-		return nil
-	}
-
-	prompt := promptui.Prompt{
-		Label:    "Back of card",
-		Validate: validate,
-		Default:  "",
-	}
-
-	result, err := prompt.Run()
-
-	if err != nil {
-		fmt.Printf("Prompt failed %v\n", err)
-	}
-	return result
-}
-
-func getFrontOfCard() string {
+func getFieldOfNote(field string, required bool) string {
 	validate := func(input string) error {
-		if len(input) == 0 {
-			return errors.New("front of card cannot be empty")
+		if required && len(input) == 0 {
+			return fmt.Errorf("%s cannot be empty", strings.ToLower(field))
 		}
 		return nil
 	}
 
 	prompt := promptui.Prompt{
-		Label:    "Front of card",
+		Label:    field,
 		Validate: validate,
 		Default:  "",
 	}
@@ -600,8 +904,8 @@ func setNameOfDeck() string {
 }
 
 func (db *DB) getCardsToReview(deckId int) *ReviewDeck {
-	stmt := "SELECT * FROM Cards WHERE datetime(ReviewDate) <= datetime('now') AND DeckId = ? ORDER BY ReviewDate"
-	rows, err := db.db.Query(stmt, deckId)
+	stmt := "SELECT " + cardColumns + " FROM Cards WHERE datetime(ReviewDate) < datetime(?) AND " + cardAvailable + " AND DeckId = ? ORDER BY ReviewDate"
+	rows, err := db.db.Query(stmt, db.dueCutoff(0), db.now().UTC(), deckId)
 	if err != nil {
 		log.Fatal("Error querying for cards", err)
 	}
@@ -612,8 +916,7 @@ func (db *DB) getCardsToReview(deckId int) *ReviewDeck {
 		Cards: []BaseCard{},
 	}
 	for rows.Next() {
-		i := BaseCard{}
-		err = rows.Scan(&i.Id, &i.DeckId, &i.Front, &i.Back, &i.Interval, &i.EaseFactor, &i.Repetition, &i.ReviewDate)
+		i, err := scanCard(rows)
 		if err != nil {
 			log.Printf("Error occurred whilst mapping cards Id: %v - error: %v", &i.Id, err)
 		}
@@ -627,10 +930,31 @@ func (d *ReviewDeck) review(db *DB) *ReviewDeck {
 		clearConsole()
 		fmt.Print("Review complete! 🎉 \n ")
 		return nil
-	} else {
+	}
+	session := hookSession{Cards: len(d.Cards), Preview: d.Preview}
+	db.fireHook("session.started", hookPayload{Session: &session})
+	d.Summary = newSessionSummary(d)
+	start := time.Now()
+	for len(d.Cards) > 0 {
 		d = d.reviewCard(db)
-		return d.review(db)
 	}
+	elapsed := time.Since(start)
+	d.Summary.finish(db, elapsed)
+	clearConsole()
+	d.Summary.print()
+	session.Answers = d.Summary.Answers
+	session.Seconds = elapsed.Seconds()
+	db.fireHook("session.finished", hookPayload{Session: &session})
+	return nil
+}
+
+// formatElapsed shows times under a minute to a tenth of a second and longer
+// ones to the second, such as 8.4s or 12m5s.
+func formatElapsed(d time.Duration) string {
+	if d < time.Minute {
+		return fmt.Sprintf("%.1fs", d.Seconds())
+	}
+	return d.Round(time.Second).String()
 }
 
 func (d *ReviewDeck) updateReviewDeck(pop bool) *ReviewDeck {
@@ -649,29 +973,75 @@ func (d *ReviewDeck) updateReviewDeck(pop bool) *ReviewDeck {
 func (d *ReviewDeck) reviewCard(db *DB) *ReviewDeck {
 	clearConsole()
 	card := &d.Cards[0]
-	qualityString := card.viewFrontAndBack()
+	start := time.Now()
+	qualityString := card.viewFrontAndBack(true)
+	for qualityString == flagKey {
+		db.setFlag(card, selectFlag())
+		qualityString = selectQuality(true)
+	}
+	elapsed := time.Since(start)
+	if qualityString == suspendKey {
+		db.setSuspended(card.Id, true)
+		clearConsole()
+		return d.updateReviewDeck(true)
+	}
+	if qualityString == buryKey {
+		db.buryCard(card.Id, 1)
+		clearConsole()
+		return d.updateReviewDeck(true)
+	}
 	quality := parseInput(qualityString)
-	pop := card.updateCard(quality, db)
+	d.Summary.record(db, card, quality, elapsed)
+	if d.Preview {
+		clearConsole()
+		return d.updateReviewDeck(quality > 3)
+	}
+	pop, leech := card.updateCard(quality, elapsed, db)
 	clearConsole()
+	if leech {
+		showLeechNotice(card)
+	}
 
+	if card.NoteId != 0 && db.getBoolSetting("bury-siblings") {
+		db.burySiblings(card)
+		d.removeSiblings(card)
+	}
 	return d.updateReviewDeck(pop)
 }
 
+// removeSiblings drops the other cards of the note of card from the queue,
+// leaving the card currently under review in first position.
+func (d *ReviewDeck) removeSiblings(card *BaseCard) {
+	noteId := card.NoteId
+	cards := d.Cards[:1]
+	for _, c := range d.Cards[1:] {
+		if c.NoteId != noteId {
+			cards = append(cards, c)
+		}
+	}
+	d.Cards = cards
+}
+
 func clearConsole() {
 	c := exec.Command("clear")
 	c.Stdout = os.Stdout
 	c.Run()
 }
 
-func (c *BaseCard) viewFrontAndBack() string {
+// viewFrontAndBack shows the card and returns the score given to it, or one
+// of the review keys when actions are allowed.
+func (c *BaseCard) viewFrontAndBack(actions bool) string {
 	viewFront(c)
 	viewBack(c)
-	input := selectQuality()
+	input := selectQuality(actions)
 	return input
 }
 
 func viewFront(card *BaseCard) {
-	fmt.Println(card.Front)
+	if card.Flag != 0 {
+		fmt.Println(flagMarker(card.Flag))
+	}
+	fmt.Println(renderCardContent(card.Front))
 }
 
 func viewBack(card *BaseCard) {
@@ -684,7 +1054,25 @@ func viewBack(card *BaseCard) {
 	if err != nil {
 		fmt.Printf("Error: %v\n", err)
 	}
-	fmt.Println(card.Back)
+	fmt.Println(renderCardContent(card.Back))
+}
+
+// showLeechNotice tells that card became a leech and waits for Enter, so
+// the next card doesn't clear the notice away.
+func showLeechNotice(card *BaseCard) {
+	fmt.Printf("Card is a leech, it has lapsed %d times\n", card.Lapses)
+	if card.Suspended {
+		fmt.Println("Its note was tagged \"" + leechTag + "\" and the card suspended")
+	} else {
+		fmt.Println("Its note was tagged \"" + leechTag + "\"")
+	}
+	prompt := promptui.Prompt{
+		Label:     "Press 'Enter' to continue",
+		IsConfirm: false,
+	}
+	if _, err := prompt.Run(); err != nil {
+		fmt.Printf("Error: %v\n", err)
+	}
 }
 
 func selectOption(menu []string, label string) string {
@@ -711,16 +1099,21 @@ func selectOption(menu []string, label string) string {
 	return result
 }
 
-func selectQuality() string {
+func selectQuality(actions bool) string {
 	possibleQuality := []string{"1", "2", "3", "4", "5"}
+	label := "Score"
+	if actions {
+		possibleQuality = append(possibleQuality, suspendKey, buryKey, flagKey)
+		label = "Score (s suspend, b bury, f flag)"
+	}
 	validate := func(input string) error {
-		if !slices.Contains(possibleQuality, strings.TrimSpace(input)) {
+		if !slices.Contains(possibleQuality, strings.ToLower(strings.TrimSpace(input))) {
 			return errors.New("score must be between 1 (lowest) - 5 (highest)")
 		}
 		return nil
 	}
 	prompt := promptui.Prompt{
-		Label:    "Score",
+		Label:    label,
 		Validate: validate,
 	}
 
@@ -730,31 +1123,74 @@ func selectQuality() string {
 		log.Fatalf("Prompt failed %v\n", err)
 	}
 
-	return result
+	return strings.ToLower(strings.TrimSpace(result))
 }
 
-func (c *BaseCard) updateCard(quality float32, db *DB) bool {
-	if quality > 3 {
-		c.Repetition = c.Repetition + 1
-		c.EaseFactor = calculateEaseFactor(c.EaseFactor, quality)
-		c.Interval = calculateInterval(c.Repetition, c.Interval, c.EaseFactor)
-		c.ReviewDate = truncateToDay(c.ReviewDate.AddDate(0, 0, c.Interval))
+// updateCard schedules the card after a review graded quality. It reports
+// whether the card leaves the session and whether it became a leech.
+func (c *BaseCard) updateCard(quality float32, elapsed time.Duration, db *DB) (bool, bool) {
+	lapsed := quality <= 3 && !db.failedToday(c.Id)
+	base := dayOfDue(c.ReviewDate)
+	delay := 0
+	if quality > 3 && db.getBoolSetting("credit-late-reviews") {
+		delay = daysBetween(base, db.today())
+		base = db.today()
+		c.Interval = max(c.Interval+lateCredit(delay, quality), 1)
+	}
+	if c.grade(quality, db.getDeckFloatSetting(c.DeckId, "interval-modifier")) {
+		// Reviewing ahead never makes a card due sooner than it was.
+		c.Interval = max(db.fuzzInterval(c.Interval, base), -delay)
+		c.ReviewDate = dueOn(base.AddDate(0, 0, c.Interval))
 		_, err := db.db.Exec("UPDATE Cards SET Repetition = ?, EaseFactor = ?, Interval = ?, ReviewDate = ? WHERE Id = ?;", c.Repetition, c.EaseFactor, c.Interval, c.ReviewDate, c.Id)
 		if err != nil {
 			fmt.Printf("Failed to update card Id: %v with error: %v", c.Id, err)
 		}
-		return true
+		db.logReview(c, quality, elapsed)
+		return true, false
 	}
 
-	c.Repetition = 0
-	c.EaseFactor = calculateEaseFactor(c.EaseFactor, quality)
-	c.Interval = calculateInterval(c.Repetition, c.Interval, c.EaseFactor)
-	_, err := db.db.Exec("UPDATE Cards SET Repetition = ?, EaseFactor = ?, Interval = ? WHERE Id = ?;", c.Repetition, c.EaseFactor, c.Interval, c.Id)
+	if lapsed {
+		c.Lapses = c.Lapses + 1
+	}
+	_, err := db.db.Exec("UPDATE Cards SET Repetition = ?, EaseFactor = ?, Interval = ?, Lapses = ? WHERE Id = ?;", c.Repetition, c.EaseFactor, c.Interval, c.Lapses, c.Id)
 	if err != nil {
 		fmt.Printf("Failed to update card Id: %v with error: %v", c.Id, err)
 	}
+	db.logReview(c, quality, elapsed)
 
-	return false
+	// A card suspended as a leech leaves the session.
+	if lapsed && db.reachedLeechThreshold(c) {
+		return db.handleLeech(c), true
+	}
+	return false, false
+}
+
+// grade applies the SM-2 update for quality to the repetition count, ease
+// factor and interval of the card, reporting whether it was recalled.
+// Intervals after the second review are scaled by modifier.
+func (c *BaseCard) grade(quality float32, modifier float64) bool {
+	recalled := quality > 3
+	if recalled {
+		c.Repetition = c.Repetition + 1
+	} else {
+		c.Repetition = 0
+	}
+	c.EaseFactor = calculateEaseFactor(c.EaseFactor, quality)
+	c.Interval = calculateInterval(c.Repetition, c.Interval, c.EaseFactor)
+	if c.Repetition > 2 {
+		c.Interval = max(int(math.Round(float64(c.Interval)*modifier)), 1)
+	}
+	return recalled
+}
+
+func (db *DB) logReview(c *BaseCard, quality float32, elapsed time.Duration) {
+	stmt := "INSERT INTO Reviews(CardId, ReviewedAt, Grade, Interval, EaseFactor, Duration) VALUES (?, ?, ?, ?, ?, ?)"
+	if _, err := db.db.Exec(stmt, c.Id, db.now().UTC(), int(quality), c.Interval, c.EaseFactor, elapsed.Milliseconds()); err != nil {
+		fmt.Printf("Failed to log review of card Id: %v with error: %v", c.Id, err)
+		return
+	}
+	record := db.newCardRecord(*c)
+	db.fireHook("card.reviewed", hookPayload{Card: &record, Grade: int(quality)})
 }
 
 func parseInput(input string) float32 {
@@ -785,6 +1221,17 @@ func calculateInterval(repetition int, previousInterval int, ef float32) int {
 	}
 }
 
+// lateCredit returns the days added to the previous interval of a card
+// recalled delay days after it was due: half of them for a 4 and all of
+// them for a 5, since it was remembered for longer than planned. A card
+// reviewed ahead has its interval shortened to the days that did pass.
+func lateCredit(delay int, quality float32) int {
+	if delay < 0 || quality >= 5 {
+		return delay
+	}
+	return delay / 2
+}
+
 func truncateToDay(t time.Time) time.Time {
 	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
 }
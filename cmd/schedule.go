@@ -0,0 +1,230 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/manifoldco/promptui"
+	"github.com/spf13/cobra"
+)
+
+// rescheduleCmd represents the reschedule command
+var rescheduleCmd = &cobra.Command{
+	Use:   "reschedule",
+	Short: "Move the due dates of the cards of a deck",
+	Long: `
+Make the cards of a deck due a random number of days from today within the
+range given by --days, e.g. 3-7, or exactly that many days for a single
+number. --due-before limits it to cards due before a date. Intervals and
+ease factors are kept.
+	`,
+	Example: `  playita reschedule --deck Spanish --days 3-7
+  playita reschedule --deck Spanish --due-before 2024-06-01 --days 1-14`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		deckName, _ := cmd.Flags().GetString("deck")
+		days, _ := cmd.Flags().GetString("days")
+		dueBefore, _ := cmd.Flags().GetString("due-before")
+
+		lo, hi, err := parseDayRange(days)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		db := openDb()
+		deck, err := db.getDeckByName(deckName)
+		if err != nil {
+			log.Fatalf("Deck %q not found\n", deckName)
+		}
+
+		stmt := "SELECT Id FROM Cards WHERE DeckId = ? AND Suspended = 0"
+		queryArgs := []any{deck.Id}
+		if dueBefore != "" {
+			day, err := time.ParseInLocation("2006-01-02", dueBefore, time.Local)
+			if err != nil {
+				log.Fatalf("invalid --due-before %q, expected YYYY-MM-DD", dueBefore)
+			}
+			stmt += " AND datetime(ReviewDate) < datetime(?)"
+			queryArgs = append(queryArgs, dueOn(day))
+		}
+		ids := db.queryCardIds(stmt, queryArgs...)
+
+		today := db.today()
+		dueDates := map[int]time.Time{}
+		for _, id := range ids {
+			dueDates[id] = dueOn(today.AddDate(0, 0, lo+db.rand.Intn(hi-lo+1)))
+		}
+		if err := db.setDueDates(dueDates); err != nil {
+			log.Fatal("Failed to reschedule cards ", err)
+		}
+		fmt.Printf("Rescheduled %d cards\n", len(dueDates))
+	},
+}
+
+// resetCmd represents the reset command
+var resetCmd = &cobra.Command{
+	Use:   "reset",
+	Short: "Turn the cards of a deck back into new cards",
+	Long: `
+Forget the progress of every card of a deck: the interval, repetitions and
+lapses go back to 0, the ease factor to the starting-ease setting of the
+deck (2.5 unless changed) and the cards are due today. The review history
+is kept.
+	`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		deckName, _ := cmd.Flags().GetString("deck")
+		yes, _ := cmd.Flags().GetBool("yes")
+
+		db := openDb()
+		deck, err := db.getDeckByName(deckName)
+		if err != nil {
+			log.Fatalf("Deck %q not found\n", deckName)
+		}
+		if !yes && !confirm(fmt.Sprintf("Reset every card of %s", deck.Name)) {
+			fmt.Println("Reset cancelled")
+			return
+		}
+
+		stmt := "UPDATE Cards SET Interval = 0, EaseFactor = ?, Repetition = 0, Lapses = 0, ReviewDate = ? WHERE DeckId = ?;"
+		res, err := db.db.Exec(stmt, db.getDeckFloatSetting(deck.Id, "starting-ease"), dueOn(db.today()), deck.Id)
+		if err != nil {
+			log.Fatal("Failed to reset cards ", err)
+		}
+		count, _ := res.RowsAffected()
+		fmt.Printf("Reset %d cards\n", count)
+	},
+}
+
+// postponeCmd represents the postpone command
+var postponeCmd = &cobra.Command{
+	Use:   "postpone",
+	Short: "Spread overdue cards over the coming days",
+	Long: `
+Spread the overdue cards evenly over the next --days days, starting today,
+so a backlog after a break doesn't have to be cleared at once. Cards with
+the shortest intervals, which are the easiest to forget, come first.
+	`,
+	Example: `  playita postpone --days 7
+  playita postpone --deck Spanish --days 14`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		deckName, _ := cmd.Flags().GetString("deck")
+		days, _ := cmd.Flags().GetInt("days")
+		if days <= 0 {
+			log.Fatal("--days must be positive")
+		}
+
+		db := openDb()
+		deckId := 0
+		if deckName != "" {
+			deck, err := db.getDeckByName(deckName)
+			if err != nil {
+				log.Fatalf("Deck %q not found\n", deckName)
+			}
+			deckId = deck.Id
+		}
+
+		today := db.today()
+		stmt := "SELECT Id FROM Cards WHERE Suspended = 0 AND datetime(ReviewDate) < datetime(?) AND (? = 0 OR DeckId = ?) ORDER BY Interval, ReviewDate"
+		ids := db.queryCardIds(stmt, dueOn(today), deckId, deckId)
+		if len(ids) == 0 {
+			fmt.Print("No overdue cards 🥳 \n ")
+			return
+		}
+
+		dueDates := map[int]time.Time{}
+		for i, id := range ids {
+			dueDates[id] = dueOn(today.AddDate(0, 0, i*days/len(ids)))
+		}
+		if err := db.setDueDates(dueDates); err != nil {
+			log.Fatal("Failed to postpone cards ", err)
+		}
+		fmt.Printf("Spread %d overdue cards over %d days\n", len(ids), min(days, len(ids)))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(rescheduleCmd)
+	rootCmd.AddCommand(resetCmd)
+	rootCmd.AddCommand(postponeCmd)
+
+	rescheduleCmd.Flags().String("deck", "", "name of the deck to reschedule")
+	rescheduleCmd.Flags().String("days", "", "days from today to make the cards due in, e.g. 3-7")
+	rescheduleCmd.Flags().String("due-before", "", "only reschedule cards due before this date (YYYY-MM-DD)")
+	rescheduleCmd.MarkFlagRequired("deck")
+	rescheduleCmd.MarkFlagRequired("days")
+
+	resetCmd.Flags().String("deck", "", "name of the deck to reset")
+	resetCmd.Flags().BoolP("yes", "y", false, "don't ask for confirmation")
+	resetCmd.MarkFlagRequired("deck")
+
+	postponeCmd.Flags().String("deck", "", "only postpone cards of this deck")
+	postponeCmd.Flags().Int("days", 7, "number of days to spread the overdue cards over")
+}
+
+// parseDayRange parses "N" or "N-M" into an inclusive range of days.
+func parseDayRange(value string) (int, int, error) {
+	from, to, isRange := strings.Cut(value, "-")
+	lo, err := strconv.Atoi(strings.TrimSpace(from))
+	hi := lo
+	if err == nil && isRange {
+		hi, err = strconv.Atoi(strings.TrimSpace(to))
+	}
+	if err != nil || lo < 0 || hi < lo {
+		return 0, 0, fmt.Errorf("invalid --days %q, expected a number of days or a range like 3-7", value)
+	}
+	return lo, hi, nil
+}
+
+func (db *DB) queryCardIds(stmt string, args ...any) []int {
+	rows, err := db.db.Query(stmt, args...)
+	if err != nil {
+		log.Fatal("Error querying for cards", err)
+	}
+	defer rows.Close()
+
+	ids := []int{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			log.Printf("Error occurred whilst mapping cards - error: %v", err)
+			continue
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// setDueDates changes the ReviewDate of every card in one transaction.
+func (db *DB) setDueDates(dueDates map[int]time.Time) error {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for id, reviewDate := range dueDates {
+		if _, err := tx.Exec("UPDATE Cards SET ReviewDate = ? WHERE Id = ?;", reviewDate, id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// confirm asks a yes/no question, defaulting to no.
+func confirm(label string) bool {
+	prompt := promptui.Prompt{
+		Label:     label,
+		IsConfirm: true,
+	}
+	_, err := prompt.Run()
+	if err != nil && !errors.Is(err, promptui.ErrAbort) {
+		log.Fatalf("Prompt failed %v\n", err)
+	}
+	return err == nil
+}
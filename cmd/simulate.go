@@ -0,0 +1,246 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// simulateCmd represents the simulate command
+var simulateCmd = &cobra.Command{
+	Use:   "simulate",
+	Short: "Estimate the future review load of a deck",
+	Long: `
+Run the scheduler forward over a copy of the cards of a deck and report the
+daily number of reviews, the time they take and the expected retention.
+Nothing is written to the database.
+
+A card is recalled with probability retention^(elapsed/interval), so it is
+remembered with the given retention on the day it is due and less the
+longer it waits. Failed cards are repeated until they are recalled, as in a
+review session. --new-per-day adds that many new cards every day. The
+starting-ease, interval-modifier and interval-fuzz settings are used, and
+the time of a review is the average answer time of the deck unless
+--seconds-per-card is given.
+
+With --output json or jsonl each day is written as:
+
+  {"schema_version": 1, "date": "2024-05-01", "reviews": 42, "new": 20,
+   "failed": 4, "minutes": 7, "retention": 0.9312}
+	`,
+	Example: `  playita simulate --deck Spanish --days 365 --retention 0.9
+  playita simulate --deck Spanish --new-per-day 20 --output csv > load.csv`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		deckName, _ := cmd.Flags().GetString("deck")
+		opts := simOptions{}
+		opts.Days, _ = cmd.Flags().GetInt("days")
+		opts.Retention, _ = cmd.Flags().GetFloat64("retention")
+		opts.NewPerDay, _ = cmd.Flags().GetInt("new-per-day")
+		opts.SecondsPerCard, _ = cmd.Flags().GetFloat64("seconds-per-card")
+		seed, _ := cmd.Flags().GetInt64("seed")
+
+		if opts.Days <= 0 {
+			log.Fatal("--days must be positive")
+		}
+		if opts.Retention <= 0 || opts.Retention >= 1 {
+			log.Fatal("--retention must be between 0 and 1")
+		}
+		if opts.NewPerDay < 0 {
+			log.Fatal("--new-per-day can't be negative")
+		}
+
+		db := openDb()
+		deck, err := db.getDeckByName(deckName)
+		if err != nil {
+			log.Fatalf("Deck %q not found\n", deckName)
+		}
+
+		opts.StartingEase = db.getDeckFloatSetting(deck.Id, "starting-ease")
+		opts.IntervalModifier = db.getDeckFloatSetting(deck.Id, "interval-modifier")
+		opts.FuzzPercent = db.getIntSetting("interval-fuzz")
+		if seconds, ok := db.getAnswerSeconds()[deck.Id]; ok && !cmd.Flags().Changed("seconds-per-card") {
+			opts.SecondsPerCard = seconds
+		}
+
+		today := db.today()
+		cards := []simCard{}
+		for _, card := range getCardsFromDeck(db, deck.Id).Cards {
+			if card.Suspended {
+				continue
+			}
+			due := max(daysBetween(today, dayOfDue(card.ReviewDate)), 0)
+			cards = append(cards, simCard{BaseCard: card, Due: due, LastReview: due - card.Interval})
+		}
+
+		days := simulate(cards, opts, rand.New(rand.NewSource(seed)))
+		for i := range days {
+			days[i].Date = today.AddDate(0, 0, i)
+		}
+		printSimulation(days)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(simulateCmd)
+
+	simulateCmd.Flags().String("deck", "", "name of the deck to simulate")
+	simulateCmd.Flags().Int("days", 365, "number of days to simulate")
+	simulateCmd.Flags().Float64("retention", 0.9, "probability of recalling a card on the day it is due")
+	simulateCmd.Flags().Int("new-per-day", 0, "new cards added every day")
+	simulateCmd.Flags().Float64("seconds-per-card", 10, "average time to answer a card, instead of the one measured for the deck")
+	simulateCmd.Flags().Int64("seed", 1, "seed of the random number generator")
+	simulateCmd.MarkFlagRequired("deck")
+}
+
+type simOptions struct {
+	Days           int
+	Retention      float64
+	NewPerDay      int
+	SecondsPerCard float64
+	// StartingEase and IntervalModifier are the scheduler settings of the
+	// deck.
+	StartingEase     float64
+	IntervalModifier float64
+	FuzzPercent      int
+}
+
+// simCard is a card with its due day and last review counted in days from
+// the start of the simulation.
+type simCard struct {
+	BaseCard
+	Due        int
+	LastReview int
+}
+
+func (c *simCard) isNew() bool {
+	return c.Repetition == 0 && c.Interval == 0
+}
+
+// recallProbability returns the chance of recalling the card on day.
+func (c *simCard) recallProbability(day int, retention float64) float64 {
+	if c.isNew() {
+		return retention
+	}
+	return math.Pow(retention, float64(day-c.LastReview)/float64(max(c.Interval, 1)))
+}
+
+type simDay struct {
+	Date    time.Time
+	Reviews int
+	New     int
+	Failed  int
+	Minutes float64
+	// Retention is the expected share of the studied cards that would be
+	// recalled at the start of the day.
+	Retention float64
+}
+
+// simulate reviews the cards due on each day with grades drawn from the
+// recall model.
+func simulate(cards []simCard, opts simOptions, rng *rand.Rand) []simDay {
+	days := make([]simDay, opts.Days)
+	for day := range days {
+		for i := 0; i < opts.NewPerDay; i++ {
+			cards = append(cards, simCard{BaseCard: BaseCard{EaseFactor: float32(opts.StartingEase)}, Due: day})
+		}
+
+		stats := &days[day]
+		studied := 0
+		for i := range cards {
+			if !cards[i].isNew() {
+				stats.Retention += cards[i].recallProbability(day, opts.Retention)
+				studied++
+			}
+		}
+		if studied > 0 {
+			stats.Retention /= float64(studied)
+		}
+
+		for i := range cards {
+			card := &cards[i]
+			if card.Due > day {
+				continue
+			}
+			if card.isNew() {
+				stats.New++
+			}
+			recalled := rng.Float64() < card.recallProbability(day, opts.Retention)
+			stats.Reviews++
+			if !recalled {
+				stats.Failed++
+				card.grade(float32(1+rng.Intn(3)), opts.IntervalModifier)
+				// Failed cards come back in the same session until recalled.
+				for rng.Float64() >= opts.Retention {
+					stats.Reviews++
+					card.grade(float32(1+rng.Intn(3)), opts.IntervalModifier)
+				}
+				stats.Reviews++
+			}
+			card.grade(float32(4+rng.Intn(2)), opts.IntervalModifier)
+			lo, hi := fuzzRange(card.Interval, opts.FuzzPercent)
+			card.Interval = lo + rng.Intn(hi-lo+1)
+			card.LastReview = day
+			card.Due = day + card.Interval
+		}
+		stats.Minutes = float64(stats.Reviews) * opts.SecondsPerCard / 60
+	}
+	return days
+}
+
+// daysBetween returns the number of calendar days from one local midnight to
+// another.
+func daysBetween(from time.Time, to time.Time) int {
+	return int(math.Round(to.Sub(from).Hours() / 24))
+}
+
+type simDayRecord struct {
+	schema
+	Date      string  `json:"date"`
+	Reviews   int     `json:"reviews"`
+	New       int     `json:"new"`
+	Failed    int     `json:"failed"`
+	Minutes   float64 `json:"minutes"`
+	Retention float64 `json:"retention"`
+}
+
+var simDayOutputColumns = []outputColumn[simDayRecord]{
+	{"date", func(d simDayRecord) string { return d.Date }},
+	{"reviews", func(d simDayRecord) string { return strconv.Itoa(d.Reviews) }},
+	{"new", func(d simDayRecord) string { return strconv.Itoa(d.New) }},
+	{"failed", func(d simDayRecord) string { return strconv.Itoa(d.Failed) }},
+	{"minutes", func(d simDayRecord) string { return strconv.FormatFloat(d.Minutes, 'f', 1, 64) }},
+	{"retention", func(d simDayRecord) string {
+		if tableOutput() {
+			return strconv.FormatFloat(d.Retention*100, 'f', 1, 64) + "%"
+		}
+		return strconv.FormatFloat(d.Retention, 'f', 4, 64)
+	}},
+}
+
+func printSimulation(days []simDay) {
+	records := []simDayRecord{}
+	reviews, minutes := 0, 0.0
+	for _, day := range days {
+		records = append(records, simDayRecord{
+			schema:    currentSchema,
+			Date:      day.Date.Format("2006-01-02"),
+			Reviews:   day.Reviews,
+			New:       day.New,
+			Failed:    day.Failed,
+			Minutes:   math.Round(day.Minutes*10) / 10,
+			Retention: math.Round(day.Retention*10000) / 10000,
+		})
+		reviews += day.Reviews
+		minutes += day.Minutes
+	}
+	printRecords(records, simDayOutputColumns)
+	if tableOutput() {
+		fmt.Printf("\n%d reviews in %d days, %.1f per day, %.1f hours in total\n", reviews, len(days), float64(reviews)/float64(len(days)), minutes/60)
+	}
+}
@@ -0,0 +1,247 @@
+package cmd
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// snapshotDir holds copies of the database taken automatically: one a day
+// and one a week, of which the backup-days and backup-weeks settings tell
+// how many are kept.
+const snapshotDir = dbFile + "-backups"
+
+const (
+	dailySnapshotPrefix  = "daily-"
+	weeklySnapshotPrefix = "weekly-"
+	// Snapshots taken before a restore are never deleted automatically.
+	restoreSnapshotPrefix = "pre-restore-"
+)
+
+var backupListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the automatic copies of the database",
+	Long: `
+List the copies of the database in ` + snapshotDir + `. A copy is taken the
+first time playita runs each day and each week; the backup-days and
+backup-weeks settings tell how many are kept. Copies taken before a
+restore are kept until deleted by hand.
+	`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		openDb()
+		snapshots, err := listSnapshots()
+		if err != nil {
+			log.Fatal("Failed to list backups ", err)
+		}
+		if len(snapshots) == 0 && tableOutput() {
+			fmt.Print("No backups found 😔 \n ")
+			return
+		}
+		printRecords(snapshots, snapshotOutputColumns)
+	},
+}
+
+var backupRestoreCmd = &cobra.Command{
+	Use:   "restore <name>",
+	Short: "Replace the database with one of its automatic copies",
+	Long: `
+Replace the database with a copy listed by backup list. The copy is checked
+with SQLite's integrity check first and the current database is copied to
+` + snapshotDir + ` before it is replaced.
+	`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		yes, _ := cmd.Flags().GetBool("yes")
+
+		name := strings.TrimSuffix(args[0], ".db") + ".db"
+		if filepath.Base(name) != name {
+			log.Fatalf("Invalid backup name %q\n", args[0])
+		}
+		path := filepath.Join(snapshotDir, name)
+		if err := verifySnapshot(path); err != nil {
+			log.Fatalf("Backup %s can't be restored: %v\n", name, err)
+		}
+
+		db := openDb()
+		if !yes && !confirm("Replace the database with "+name) {
+			fmt.Println("Restore cancelled")
+			return
+		}
+		safety := restoreSnapshotPrefix + time.Now().Format("2006-01-02-150405") + ".db"
+		if err := db.vacuumInto(filepath.Join(snapshotDir, safety)); err != nil {
+			log.Fatal("Failed to back up the current database ", err)
+		}
+		db.db.Close()
+		if err := replaceFile(path, dbFile+".db"); err != nil {
+			log.Fatal("Failed to restore backup ", err)
+		}
+		fmt.Printf("Restored %s, the previous database was saved as %s\n", name, safety)
+	},
+}
+
+func init() {
+	backupCmd.AddCommand(backupListCmd, backupRestoreCmd)
+
+	backupRestoreCmd.Flags().BoolP("yes", "y", false, "don't ask for confirmation")
+}
+
+type snapshotRecord struct {
+	schema
+	Name      string    `json:"name"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+var snapshotOutputColumns = []outputColumn[snapshotRecord]{
+	{"name", func(s snapshotRecord) string { return s.Name }},
+	{"size", func(s snapshotRecord) string { return strconv.FormatInt(s.Size, 10) }},
+	{"created_at", func(s snapshotRecord) string { return s.CreatedAt.Format(time.RFC3339) }},
+}
+
+// autoSnapshot takes today's and this week's copy of the database when
+// they don't exist yet and deletes the oldest ones beyond the settings.
+// Copies are named after the system clock rather than the clock of the DB:
+// a date pretended with --now would sort after the real copies and have
+// them pruned.
+func (db *DB) autoSnapshot() error {
+	today := db.studyDay(time.Now())
+	year, week := today.ISOWeek()
+	kinds := []struct {
+		prefix string
+		name   string
+		keep   int
+	}{
+		{dailySnapshotPrefix, today.Format("2006-01-02"), db.getIntSetting("backup-days")},
+		{weeklySnapshotPrefix, fmt.Sprintf("%d-W%02d", year, week), db.getIntSetting("backup-weeks")},
+	}
+	for _, kind := range kinds {
+		if kind.keep == 0 {
+			continue
+		}
+		path := filepath.Join(snapshotDir, kind.prefix+kind.name+".db")
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			if err := db.vacuumInto(path); err != nil {
+				return err
+			}
+		}
+		if err := pruneSnapshots(kind.prefix, kind.keep); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// vacuumInto writes a compacted copy of the database to path while it
+// stays usable.
+func (db *DB) vacuumInto(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	os.Remove(tmp)
+	if _, err := db.db.Exec("VACUUM INTO ?", tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// pruneSnapshots deletes all but the newest keep snapshots with prefix.
+// Their names sort by date.
+func pruneSnapshots(prefix string, keep int) error {
+	paths, err := filepath.Glob(filepath.Join(snapshotDir, prefix+"[0-9]*.db"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(paths)
+	for len(paths) > keep {
+		if err := os.Remove(paths[0]); err != nil {
+			return err
+		}
+		paths = paths[1:]
+	}
+	return nil
+}
+
+func listSnapshots() ([]snapshotRecord, error) {
+	paths, err := filepath.Glob(filepath.Join(snapshotDir, "*.db"))
+	if err != nil {
+		return nil, err
+	}
+	snapshots := []snapshotRecord{}
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, snapshotRecord{schema: currentSchema, Name: strings.TrimSuffix(filepath.Base(path), ".db"), Size: info.Size(), CreatedAt: info.ModTime()})
+	}
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].CreatedAt.After(snapshots[j].CreatedAt)
+	})
+	return snapshots, nil
+}
+
+// verifySnapshot opens a copy read-only and runs SQLite's integrity check
+// on it.
+func verifySnapshot(path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+	snapshot, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer snapshot.Close()
+
+	var result string
+	if err := snapshot.QueryRow("PRAGMA integrity_check").Scan(&result); err != nil {
+		return err
+	}
+	if result != "ok" {
+		return fmt.Errorf("integrity check failed: %s", result)
+	}
+	var tables int
+	if err := snapshot.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name IN ('Cards', 'Decks')").Scan(&tables); err != nil {
+		return err
+	}
+	if tables != 2 {
+		return fmt.Errorf("not a playita database")
+	}
+	return nil
+}
+
+// replaceFile copies src over dst through a temporary file, so dst is
+// never left half written.
+func replaceFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp := dst + ".restoring"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, dst)
+}
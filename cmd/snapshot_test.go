@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// chdirTemp runs the rest of a test in an empty directory.
+func chdirTemp(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	previous, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(previous) })
+	return dir
+}
+
+func TestAutoSnapshotIgnoresPretendedDate(t *testing.T) {
+	chdirTemp(t)
+	db, _ := newTestDb(t, localTime(2030, 1, 1, 12, 0))
+	mustSetSetting(t, db, "backup-days", "3")
+	mustSetSetting(t, db, "backup-weeks", "1")
+
+	today := db.studyDay(time.Now())
+	if err := os.MkdirAll(snapshotDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for days := 1; days <= 5; days++ {
+		name := dailySnapshotPrefix + today.AddDate(0, 0, -days).Format("2006-01-02") + ".db"
+		if err := os.WriteFile(filepath.Join(snapshotDir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := db.autoSnapshot(); err != nil {
+		t.Fatal(err)
+	}
+	daily, err := filepath.Glob(filepath.Join(snapshotDir, dailySnapshotPrefix+"*.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{}
+	for days := 2; days >= 0; days-- {
+		want = append(want, filepath.Join(snapshotDir, dailySnapshotPrefix+today.AddDate(0, 0, -days).Format("2006-01-02")+".db"))
+	}
+	if len(daily) != len(want) {
+		t.Fatalf("daily copies %v, want %v", daily, want)
+	}
+	for i := range want {
+		if daily[i] != want[i] {
+			t.Errorf("daily copies %v, want %v", daily, want)
+			break
+		}
+	}
+
+	year, week := today.ISOWeek()
+	weekly, _ := filepath.Glob(filepath.Join(snapshotDir, weeklySnapshotPrefix+"*.db"))
+	if len(weekly) != 1 || filepath.Base(weekly[0]) != fmt.Sprintf("%s%d-W%02d.db", weeklySnapshotPrefix, year, week) {
+		t.Errorf("weekly copies %v, want this week's", weekly)
+	}
+}
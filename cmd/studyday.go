@@ -0,0 +1,123 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+)
+
+// A study day runs from the rollover hour to the same hour the next day, in
+// local time, so reviews done shortly after midnight still count for the
+// evening before. Due dates are stored in UTC at local noon of the study day
+// they fall on: the date stays the same when the rollover hour changes or the
+// user moves a few time zones, and a card is due when its ReviewDate is
+// before noon of the next study day.
+const dueHour = 12
+
+// schemaVersionStudyDays is the user_version from which every date is
+// stored in UTC and ReviewDate follows the rule above.
+const schemaVersionStudyDays = 1
+
+func (db *DB) rolloverHour() int {
+	return db.getIntSetting("rollover-hour")
+}
+
+// studyDay returns local midnight of the date of the study day t falls in.
+func (db *DB) studyDay(t time.Time) time.Time {
+	return truncateToDay(t.In(time.Local).Add(-time.Duration(db.rolloverHour()) * time.Hour))
+}
+
+func (db *DB) today() time.Time {
+	return db.studyDay(db.now())
+}
+
+// dueOn returns the ReviewDate of a card due on day.
+func dueOn(day time.Time) time.Time {
+	return time.Date(day.Year(), day.Month(), day.Day(), dueHour, 0, 0, 0, time.Local).UTC()
+}
+
+// dayOfDue returns the study day a card with reviewDate is due on, the
+// inverse of dueOn. It goes by the local date rather than studyDay, which
+// would put noon on the previous date for rollover hours past noon. Dates
+// before noon, written by other tools, are due the day before, as
+// dueCutoff has it.
+func dayOfDue(reviewDate time.Time) time.Time {
+	local := reviewDate.In(time.Local)
+	day := truncateToDay(local)
+	if local.Hour() < dueHour {
+		day = day.AddDate(0, 0, -1)
+	}
+	return day
+}
+
+// dayStart returns the moment the study day begins.
+func (db *DB) dayStart(day time.Time) time.Time {
+	return time.Date(day.Year(), day.Month(), day.Day(), db.rolloverHour(), 0, 0, 0, time.Local).UTC()
+}
+
+// dueCutoff returns the ReviewDate before which cards are due, looking
+// daysAhead study days past today.
+func (db *DB) dueCutoff(daysAhead int) time.Time {
+	return dueOn(db.today().AddDate(0, 0, daysAhead+1))
+}
+
+// migrateStudyDays converts dates written by older versions, which mixed
+// local and UTC times and truncated due dates to local midnight, to UTC and
+// moves due dates to noon of their study day.
+func (db *DB) migrateStudyDays() error {
+	var version int
+	if err := db.db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+	if version >= schemaVersionStudyDays {
+		return nil
+	}
+
+	type cardDates struct {
+		id          int
+		reviewDate  time.Time
+		buriedUntil *time.Time
+	}
+	rows, err := db.db.Query("SELECT Id, ReviewDate, BuriedUntil FROM Cards")
+	if err != nil {
+		return err
+	}
+	cards := []cardDates{}
+	for rows.Next() {
+		c := cardDates{}
+		if err := rows.Scan(&c.id, &c.reviewDate, &c.buriedUntil); err != nil {
+			rows.Close()
+			return err
+		}
+		cards = append(cards, c)
+	}
+	rows.Close()
+
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, c := range cards {
+		// Midnight in the stored zone means truncateToDay picked the date.
+		day := db.studyDay(c.reviewDate)
+		if c.reviewDate.Equal(truncateToDay(c.reviewDate)) {
+			day = time.Date(c.reviewDate.Year(), c.reviewDate.Month(), c.reviewDate.Day(), 0, 0, 0, 0, time.Local)
+		}
+		var buriedUntil any
+		if c.buriedUntil != nil {
+			buriedUntil = c.buriedUntil.UTC()
+		}
+		if _, err := tx.Exec("UPDATE Cards SET ReviewDate = ?, BuriedUntil = ? WHERE Id = ?;", dueOn(day), buriedUntil, c.id); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec("UPDATE Reviews SET ReviewedAt = strftime('%Y-%m-%d %H:%M:%f+00:00', ReviewedAt);"); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", schemaVersionStudyDays)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,190 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// sessionSummary is shown once a review session is over, or written as a
+// record with --output json, jsonl or csv.
+type sessionSummary struct {
+	schema
+	// Cards counts the cards graded at least once and Answers every grade
+	// given, including the repeats of failed cards.
+	Cards   int `json:"cards"`
+	Answers int `json:"answers"`
+	// New counts the cards graded for the first time ever, Review the
+	// others.
+	New    int         `json:"new"`
+	Review int         `json:"review"`
+	Grades map[int]int `json:"grades"`
+	// CorrectPercent is the share of answers graded 4 or 5.
+	CorrectPercent float64 `json:"correct_percent"`
+	// Seconds is the length of the session and SecondsPerCard the average
+	// time from showing a front to submitting its grade.
+	Seconds        float64      `json:"seconds"`
+	SecondsPerCard float64      `json:"seconds_per_card"`
+	Failed         []failedCard `json:"failed"`
+	// DueTomorrow counts the cards of the decks in the session due by the
+	// end of the next study day.
+	DueTomorrow int  `json:"due_tomorrow"`
+	Preview     bool `json:"preview"`
+
+	deckIds    []int
+	seen       map[int]bool
+	answerTime time.Duration
+}
+
+// failedCard is a card graded 3 or lower during the session.
+type failedCard struct {
+	Id    int    `json:"id"`
+	Front string `json:"front"`
+}
+
+var sessionSummaryColumns = []outputColumn[sessionSummary]{
+	{"cards", func(s sessionSummary) string { return strconv.Itoa(s.Cards) }},
+	{"answers", func(s sessionSummary) string { return strconv.Itoa(s.Answers) }},
+	{"new", func(s sessionSummary) string { return strconv.Itoa(s.New) }},
+	{"review", func(s sessionSummary) string { return strconv.Itoa(s.Review) }},
+	{"grade_1", func(s sessionSummary) string { return strconv.Itoa(s.Grades[1]) }},
+	{"grade_2", func(s sessionSummary) string { return strconv.Itoa(s.Grades[2]) }},
+	{"grade_3", func(s sessionSummary) string { return strconv.Itoa(s.Grades[3]) }},
+	{"grade_4", func(s sessionSummary) string { return strconv.Itoa(s.Grades[4]) }},
+	{"grade_5", func(s sessionSummary) string { return strconv.Itoa(s.Grades[5]) }},
+	{"correct_percent", func(s sessionSummary) string { return strconv.FormatFloat(s.CorrectPercent, 'f', -1, 64) }},
+	{"seconds", func(s sessionSummary) string { return strconv.FormatFloat(s.Seconds, 'f', -1, 64) }},
+	{"seconds_per_card", func(s sessionSummary) string { return strconv.FormatFloat(s.SecondsPerCard, 'f', -1, 64) }},
+	{"failed", func(s sessionSummary) string { return strconv.Itoa(len(s.Failed)) }},
+	{"due_tomorrow", func(s sessionSummary) string { return strconv.Itoa(s.DueTomorrow) }},
+	{"preview", func(s sessionSummary) string { return strconv.FormatBool(s.Preview) }},
+}
+
+// newSessionSummary starts the summary of a session over the cards of deck.
+func newSessionSummary(deck *ReviewDeck) sessionSummary {
+	s := sessionSummary{
+		schema:  currentSchema,
+		Grades:  map[int]int{1: 0, 2: 0, 3: 0, 4: 0, 5: 0},
+		Failed:  []failedCard{},
+		Preview: deck.Preview,
+		seen:    map[int]bool{},
+	}
+	decks := map[int]bool{}
+	for _, card := range deck.Cards {
+		if !decks[card.DeckId] {
+			decks[card.DeckId] = true
+			s.deckIds = append(s.deckIds, card.DeckId)
+		}
+	}
+	return s
+}
+
+// record counts a grade given to card after elapsed. It is called before
+// the review is logged, so a card is new when it has no reviews yet.
+func (s *sessionSummary) record(db *DB, card *BaseCard, quality float32, elapsed time.Duration) {
+	grade := int(quality)
+	s.Answers++
+	s.Grades[grade]++
+	s.answerTime += elapsed
+	if !s.seen[card.Id] {
+		s.seen[card.Id] = true
+		s.Cards++
+		if db.hasReviews(card.Id) {
+			s.Review++
+		} else {
+			s.New++
+		}
+	}
+	if grade <= 3 && !s.hasFailed(card.Id) {
+		s.Failed = append(s.Failed, failedCard{Id: card.Id, Front: strings.Join(strings.Fields(card.Front), " ")})
+	}
+}
+
+func (s *sessionSummary) hasFailed(cardId int) bool {
+	for _, failed := range s.Failed {
+		if failed.Id == cardId {
+			return true
+		}
+	}
+	return false
+}
+
+// finish fills in the totals of a session that lasted elapsed.
+func (s *sessionSummary) finish(db *DB, elapsed time.Duration) {
+	s.Seconds = roundTenth(elapsed.Seconds())
+	if s.Answers > 0 {
+		correct := s.Grades[4] + s.Grades[5]
+		s.CorrectPercent = roundTenth(float64(correct) * 100 / float64(s.Answers))
+		s.SecondsPerCard = roundTenth(s.answerTime.Seconds() / float64(s.Answers))
+	}
+	s.DueTomorrow = db.countDueTomorrow(s.deckIds)
+}
+
+func roundTenth(f float64) float64 {
+	return math.Round(f*10) / 10
+}
+
+// print shows the summary, or writes it as a record when --output isn't
+// table.
+func (s sessionSummary) print() {
+	if !tableOutput() {
+		printRecords([]sessionSummary{s}, sessionSummaryColumns)
+		return
+	}
+	fmt.Print("Review complete! 🎉 \n ")
+	fmt.Println()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Cards reviewed\t%d (%d new, %d review)\n", s.Cards, s.New, s.Review)
+	if s.Answers != s.Cards {
+		fmt.Fprintf(w, "Answers\t%d\n", s.Answers)
+	}
+	grades := []string{}
+	for grade := 1; grade <= 5; grade++ {
+		grades = append(grades, fmt.Sprintf("%d: %d", grade, s.Grades[grade]))
+	}
+	fmt.Fprintf(w, "Grades\t%s\n", strings.Join(grades, "  "))
+	fmt.Fprintf(w, "Correct\t%s%%\n", strconv.FormatFloat(s.CorrectPercent, 'f', -1, 64))
+	fmt.Fprintf(w, "Time\t%s, %s per card\n", formatElapsed(time.Duration(s.Seconds*float64(time.Second))), formatElapsed(time.Duration(s.SecondsPerCard*float64(time.Second))))
+	fmt.Fprintf(w, "Due tomorrow\t%d\n", s.DueTomorrow)
+	w.Flush()
+	if len(s.Failed) > 0 {
+		fmt.Println()
+		fmt.Println("Failed cards:")
+		for _, failed := range s.Failed {
+			fmt.Printf("  %s\n", failed.Front)
+		}
+	}
+}
+
+func (db *DB) hasReviews(cardId int) bool {
+	var found bool
+	if err := db.db.QueryRow("SELECT EXISTS(SELECT 1 FROM Reviews WHERE CardId = ?)", cardId).Scan(&found); err != nil {
+		log.Printf("Error occurred whilst reading reviews of card Id: %v - error: %v", cardId, err)
+	}
+	return found
+}
+
+// countDueTomorrow counts the cards of the given decks that will be due by
+// the end of the next study day.
+func (db *DB) countDueTomorrow(deckIds []int) int {
+	if len(deckIds) == 0 {
+		return 0
+	}
+	tomorrow := db.dayStart(db.today().AddDate(0, 0, 1))
+	args := []any{tomorrow, db.dueCutoff(1)}
+	for _, id := range deckIds {
+		args = append(args, id)
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(deckIds)), ", ")
+	stmt := "SELECT COUNT(*) FROM Cards WHERE " + cardAvailable + " AND datetime(ReviewDate) < datetime(?) AND DeckId IN (" + placeholders + ")"
+	var count int
+	if err := db.db.QueryRow(stmt, args...).Scan(&count); err != nil {
+		log.Printf("Error occurred whilst counting cards due tomorrow: %v", err)
+	}
+	return count
+}
@@ -0,0 +1,709 @@
+package cmd
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/exp/slices"
+)
+
+// syncTables are the tables shared between devices, parents before their
+// children so references resolve when changes are applied in this order.
+// Settings stay on each device.
+var syncTables = []string{"Decks", "NoteTypes", "Notes", "Cards", "Reviews"}
+
+// schemaVersionSync is the user_version from which every synced row has a
+// Guid and a Modified time and changes are recorded in ChangeLog.
+const schemaVersionSync = 2
+
+// syncNow is the SQL for the current time in the format dates are stored
+// in. Modification times come from the system clock, not --now, since they
+// are compared between devices.
+const syncNow = "strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')"
+
+// syncCmd represents the sync command
+var syncCmd = &cobra.Command{
+	Use:   "sync",
+	Short: "Send and receive changes through a sync server",
+	Long: `
+Send the changes made since the last sync to a server started with
+playita sync-server and apply the changes other devices sent to it.
+
+Every deck, note type, note and card records when it was last changed and
+the newest change wins when two devices change the same one, except that
+the scheduling of a card comes from the device that reviewed it last.
+Reviews are only ever added, so the reviews done on every device are kept.
+
+The server, user and token are read from the sync-url, sync-user and
+sync-token settings unless given as flags.
+	`,
+	Example: `  playita sync-server add-user alice
+  playita config sync-url http://192.168.1.10:8765
+  playita config sync-user alice
+  playita config sync-token <token>
+  playita sync`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		db := openDb()
+		client := syncClient{http: &http.Client{Timeout: time.Minute}}
+		for flag, setting := range map[string]*string{"url": &client.url, "user": &client.user, "token": &client.token} {
+			*setting, _ = cmd.Flags().GetString(flag)
+			if *setting == "" {
+				*setting = db.getSetting("sync-" + flag)
+			}
+			if *setting == "" {
+				log.Fatalf("No sync %s, set it with playita config sync-%s\n", flag, flag)
+			}
+		}
+
+		sent, received, err := db.sync(client)
+		if err != nil {
+			log.Fatal("Sync failed ", err)
+		}
+		fmt.Printf("Sync complete, sent %d and received %d changes\n", sent, received)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(syncCmd)
+
+	syncCmd.Flags().String("url", "", "address of the sync server")
+	syncCmd.Flags().String("user", "", "user on the sync server")
+	syncCmd.Flags().String("token", "", "token of the user")
+}
+
+// syncEntity is a row of a synced table as it travels between devices and
+// the server. Data references other rows by Guid.
+type syncEntity struct {
+	Table    string          `json:"table"`
+	Guid     string          `json:"guid"`
+	Modified time.Time       `json:"modified"`
+	Deleted  bool            `json:"deleted,omitempty"`
+	Data     json.RawMessage `json:"data,omitempty"`
+}
+
+type syncDeck struct {
+	Name string `json:"name"`
+}
+
+type syncNoteType struct {
+	Name      string           `json:"name"`
+	Fields    []string         `json:"fields"`
+	Templates []templateRecord `json:"templates"`
+}
+
+type syncNote struct {
+	Deck     string            `json:"deck"`
+	NoteType string            `json:"note_type"`
+	Fields   map[string]string `json:"fields"`
+	Tags     string            `json:"tags"`
+}
+
+type syncCard struct {
+	Deck        string     `json:"deck"`
+	Note        string     `json:"note"`
+	Ord         int        `json:"ord"`
+	Front       string     `json:"front"`
+	Back        string     `json:"back"`
+	Interval    int        `json:"interval"`
+	EaseFactor  float32    `json:"ease_factor"`
+	Repetition  int        `json:"repetition"`
+	ReviewDate  time.Time  `json:"review_date"`
+	BuriedUntil *time.Time `json:"buried_until"`
+	Suspended   bool       `json:"suspended"`
+	Flag        int        `json:"flag"`
+	Lapses      int        `json:"lapses"`
+	// LastReview is when the card was last reviewed on the device that sent
+	// it, which decides whose scheduling is kept.
+	LastReview *time.Time `json:"last_review,omitempty"`
+}
+
+type syncReview struct {
+	Card       string    `json:"card"`
+	ReviewedAt time.Time `json:"reviewed_at"`
+	Grade      int       `json:"grade"`
+	Interval   int       `json:"interval"`
+	EaseFactor float32   `json:"ease_factor"`
+	Duration   int64     `json:"duration_ms,omitempty"`
+}
+
+// syncPush is the body of a push, syncPull the answer to a pull. Seq is the
+// position in the server's log the device has seen.
+type syncPush struct {
+	Entities []syncEntity `json:"entities"`
+}
+
+type syncPull struct {
+	Entities []syncEntity `json:"entities"`
+	Seq      int64        `json:"seq"`
+}
+
+type syncClient struct {
+	http  *http.Client
+	url   string
+	user  string
+	token string
+}
+
+func (c syncClient) do(method string, path string, body any, out any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, strings.TrimSuffix(c.url, "/")+path, reader)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(c.user, c.token)
+	req.Header.Set("Content-Type", "application/json")
+	res, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		return fmt.Errorf("server answered %s: %s", res.Status, strings.TrimSpace(string(message)))
+	}
+	return json.NewDecoder(res.Body).Decode(out)
+}
+
+// sync pushes the local changes, then pulls and applies everything the
+// server received since the last pull, including what was just pushed,
+// which is skipped as it is not newer than the local rows.
+func (db *DB) sync(client syncClient) (int, int, error) {
+	pushed := db.getSyncState("pushed")
+	changes, lastChange, err := db.localChanges(pushed)
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(changes) > 0 {
+		var ack syncPull
+		if err := client.do(http.MethodPost, "/sync/push", syncPush{Entities: changes}, &ack); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	var pull syncPull
+	query := "/sync/pull?since=" + url.QueryEscape(strconv.FormatInt(db.getSyncState("pulled"), 10))
+	if err := client.do(http.MethodGet, query, nil, &pull); err != nil {
+		return len(changes), 0, err
+	}
+	received, err := db.applyChanges(pull, lastChange)
+	return len(changes), received, err
+}
+
+func (db *DB) getSyncState(key string) int64 {
+	var value int64
+	err := db.db.QueryRow("SELECT Value FROM SyncState WHERE Key = ?", key).Scan(&value)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Printf("Error occurred whilst reading sync state %v - error: %v", key, err)
+	}
+	return value
+}
+
+// localChanges returns the current state of every row changed after the
+// ChangeLog entry since, and the last entry.
+func (db *DB) localChanges(since int64) ([]syncEntity, int64, error) {
+	type change struct {
+		table     string
+		guid      string
+		deleted   bool
+		changedAt time.Time
+		id        int64
+	}
+	changes := map[string]change{}
+	last := since
+	err := scanEach(db.db, fmt.Sprintf("SELECT Id, TableName, Guid, Deleted, ChangedAt FROM ChangeLog WHERE Id > %d ORDER BY Id", since), func(rows *sql.Rows) error {
+		c := change{}
+		if err := rows.Scan(&c.id, &c.table, &c.guid, &c.deleted, &c.changedAt); err != nil {
+			return err
+		}
+		changes[c.table+" "+c.guid] = c
+		last = c.id
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// Rows are sent in the order they last changed, so other devices
+	// create them in the same order.
+	ordered := make([]change, 0, len(changes))
+	for _, c := range changes {
+		ordered = append(ordered, c)
+	}
+	sort.Slice(ordered, func(i, j int) bool { return ordered[i].id < ordered[j].id })
+	entities := []syncEntity{}
+	for _, c := range ordered {
+		entity, err := db.readEntity(c.table, c.guid)
+		if errors.Is(err, sql.ErrNoRows) {
+			entity = syncEntity{Table: c.table, Guid: c.guid, Modified: c.changedAt.UTC(), Deleted: true}
+		} else if err != nil {
+			return nil, 0, err
+		}
+		entities = append(entities, entity)
+	}
+	sortEntities(entities)
+	return entities, last, nil
+}
+
+// sortEntities puts parents before their children.
+func sortEntities(entities []syncEntity) {
+	sort.SliceStable(entities, func(i, j int) bool {
+		return slices.Index(syncTables, entities[i].Table) < slices.Index(syncTables, entities[j].Table)
+	})
+}
+
+// readEntity reads a row by Guid, returning sql.ErrNoRows when it was
+// deleted.
+func (db *DB) readEntity(table string, guid string) (syncEntity, error) {
+	entity := syncEntity{Table: table, Guid: guid}
+	var data any
+	switch table {
+	case "Decks":
+		deck := syncDeck{}
+		err := db.db.QueryRow("SELECT Name, Modified FROM Decks WHERE Guid = ?", guid).Scan(&deck.Name, &entity.Modified)
+		if err != nil {
+			return entity, err
+		}
+		data = deck
+	case "NoteTypes":
+		nt := syncNoteType{}
+		var id int
+		var fields string
+		err := db.db.QueryRow("SELECT Id, Name, Fields, Modified FROM NoteTypes WHERE Guid = ?", guid).Scan(&id, &nt.Name, &fields, &entity.Modified)
+		if err != nil {
+			return entity, err
+		}
+		if err := json.Unmarshal([]byte(fields), &nt.Fields); err != nil {
+			return entity, err
+		}
+		templates, err := db.getCardTemplates(id)
+		if err != nil {
+			return entity, err
+		}
+		nt.Templates = newNoteTypeRecord(NoteType{Templates: templates}).Templates
+		data = nt
+	case "Notes":
+		note := syncNote{}
+		var fields string
+		stmt := "SELECT COALESCE(d.Guid, ''), COALESCE(nt.Guid, ''), n.Fields, n.Tags, n.Modified FROM Notes n LEFT JOIN Decks d ON d.Id = n.DeckId LEFT JOIN NoteTypes nt ON nt.Id = n.NoteTypeId WHERE n.Guid = ?"
+		err := db.db.QueryRow(stmt, guid).Scan(&note.Deck, &note.NoteType, &fields, &note.Tags, &entity.Modified)
+		if err != nil {
+			return entity, err
+		}
+		if err := json.Unmarshal([]byte(fields), &note.Fields); err != nil {
+			return entity, err
+		}
+		data = note
+	case "Cards":
+		card, modified, err := querySyncCard(db.db, guid)
+		if err != nil {
+			return entity, err
+		}
+		entity.Modified = modified
+		data = card
+	case "Reviews":
+		review := syncReview{}
+		stmt := "SELECT COALESCE(c.Guid, ''), r.ReviewedAt, r.Grade, r.Interval, r.EaseFactor, r.Duration FROM Reviews r LEFT JOIN Cards c ON c.Id = r.CardId WHERE r.Guid = ?"
+		err := db.db.QueryRow(stmt, guid).Scan(&review.Card, &review.ReviewedAt, &review.Grade, &review.Interval, &review.EaseFactor, &review.Duration)
+		if err != nil {
+			return entity, err
+		}
+		review.ReviewedAt = review.ReviewedAt.UTC()
+		entity.Modified = review.ReviewedAt
+		data = review
+	default:
+		return entity, fmt.Errorf("unknown table %q", table)
+	}
+	entity.Modified = entity.Modified.UTC()
+	raw, err := json.Marshal(data)
+	entity.Data = raw
+	return entity, err
+}
+
+// rowQuerier is a database or a transaction.
+type rowQuerier interface {
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// querySyncCard reads a card by Guid with the time it was changed.
+func querySyncCard(q rowQuerier, guid string) (syncCard, time.Time, error) {
+	card := syncCard{}
+	var id int
+	var modified time.Time
+	var buriedUntil, lastReview sql.NullTime
+	stmt := "SELECT c.Id, COALESCE(d.Guid, ''), COALESCE(n.Guid, ''), c.Ord, c.Front, c.Back, c.Interval, c.EaseFactor, c.Repetition, c.ReviewDate, c.BuriedUntil, c.Suspended, c.Flag, c.Lapses, c.Modified FROM Cards c LEFT JOIN Decks d ON d.Id = c.DeckId LEFT JOIN Notes n ON n.Id = c.NoteId WHERE c.Guid = ?"
+	err := q.QueryRow(stmt, guid).Scan(&id, &card.Deck, &card.Note, &card.Ord, &card.Front, &card.Back, &card.Interval, &card.EaseFactor, &card.Repetition, &card.ReviewDate, &buriedUntil, &card.Suspended, &card.Flag, &card.Lapses, &modified)
+	if err != nil {
+		return card, modified, err
+	}
+	err = q.QueryRow("SELECT ReviewedAt FROM Reviews WHERE CardId = ? ORDER BY datetime(ReviewedAt) DESC LIMIT 1", id).Scan(&lastReview)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return card, modified, err
+	}
+	card.ReviewDate = card.ReviewDate.UTC()
+	if buriedUntil.Valid {
+		t := buriedUntil.Time.UTC()
+		card.BuriedUntil = &t
+	}
+	if lastReview.Valid {
+		t := lastReview.Time.UTC()
+		card.LastReview = &t
+	}
+	return card, modified.UTC(), nil
+}
+
+// mergeSyncCards resolves two versions of a card. The one changed last
+// wins, except for the scheduling, which comes from the one reviewed last
+// so a review isn't undone by an edit made elsewhere, as git-sync does with
+// gitScheduleKeys.
+func mergeSyncCards(a syncEntity, b syncEntity) (syncEntity, error) {
+	if a.Modified.After(b.Modified) {
+		a, b = b, a
+	}
+	older, newer := syncCard{}, syncCard{}
+	if err := json.Unmarshal(a.Data, &older); err != nil {
+		return b, err
+	}
+	if err := json.Unmarshal(b.Data, &newer); err != nil {
+		return b, err
+	}
+	if older.LastReview != nil && (newer.LastReview == nil || older.LastReview.After(*newer.LastReview)) {
+		newer.Interval, newer.EaseFactor, newer.Repetition = older.Interval, older.EaseFactor, older.Repetition
+		newer.ReviewDate, newer.BuriedUntil, newer.Lapses = older.ReviewDate, older.BuriedUntil, older.Lapses
+		newer.LastReview = older.LastReview
+	}
+	data, err := json.Marshal(newer)
+	return syncEntity{Table: b.Table, Guid: b.Guid, Modified: b.Modified, Data: data}, err
+}
+
+// mergeLocalCard merges a pulled card with the local one, reporting
+// whether the local one changes.
+func mergeLocalCard(tx *sql.Tx, id int, e syncEntity) (syncEntity, bool, error) {
+	card, modified, err := querySyncCard(tx, e.Guid)
+	if err != nil {
+		return e, false, err
+	}
+	local := syncEntity{Table: e.Table, Guid: e.Guid, Modified: modified}
+	if local.Data, err = json.Marshal(card); err != nil {
+		return e, false, err
+	}
+	merged, err := mergeSyncCards(local, e)
+	if err != nil || string(merged.Data) == string(local.Data) {
+		return merged, false, err
+	}
+	if merged.Modified.Equal(modified) {
+		// Writing the same Modified would be logged as a local change;
+		// clearing it first isn't.
+		if _, err := tx.Exec("UPDATE Cards SET Modified = NULL WHERE Id = ?", id); err != nil {
+			return merged, false, err
+		}
+	}
+	return merged, true, nil
+}
+
+// applyChanges applies pulled entities in one transaction and records the
+// sync position. Applying them must not be logged as local changes, so the
+// entries the triggers add are removed and the push position moves to the
+// last local change that was pushed.
+func (db *DB) applyChanges(pull syncPull, pushed int64) (int, error) {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var lastLog int64
+	if err := tx.QueryRow("SELECT COALESCE(MAX(Id), 0) FROM ChangeLog").Scan(&lastLog); err != nil {
+		return 0, err
+	}
+
+	entities := slices.Clone(pull.Entities)
+	sortEntities(entities)
+	applied := 0
+	for _, entity := range entities {
+		ok, err := applyEntity(tx, entity)
+		if err != nil {
+			return 0, fmt.Errorf("%s %s: %w", entity.Table, entity.Guid, err)
+		}
+		if ok {
+			applied++
+		}
+	}
+
+	if _, err := tx.Exec("DELETE FROM ChangeLog WHERE Id > ? OR Id <= ?", lastLog, pushed); err != nil {
+		return 0, err
+	}
+	for key, value := range map[string]int64{"pushed": pushed, "pulled": pull.Seq} {
+		if _, err := tx.Exec("INSERT INTO SyncState(Key, Value) VALUES (?, ?) ON CONFLICT(Key) DO UPDATE SET Value = excluded.Value", key, value); err != nil {
+			return 0, err
+		}
+	}
+	return applied, tx.Commit()
+}
+
+// applyEntity writes an entity unless the local row changed later,
+// reporting whether it did. Cards are merged with mergeSyncCards. Rows whose parent is missing are skipped.
+func applyEntity(tx *sql.Tx, e syncEntity) (bool, error) {
+	if !slices.Contains(syncTables, e.Table) {
+		return false, errors.New("unknown table")
+	}
+
+	var id int
+	var modified sql.NullTime
+	found := true
+	stmt := "SELECT Id, Modified FROM " + e.Table + " WHERE Guid = ?"
+	if e.Table == "Reviews" {
+		stmt = "SELECT Id, NULL FROM Reviews WHERE Guid = ?"
+	}
+	if err := tx.QueryRow(stmt, e.Guid).Scan(&id, &modified); errors.Is(err, sql.ErrNoRows) {
+		found = false
+	} else if err != nil {
+		return false, err
+	}
+
+	if e.Deleted {
+		if !found || modified.Valid && modified.Time.After(e.Modified) {
+			return false, nil
+		}
+		_, err := tx.Exec("DELETE FROM "+e.Table+" WHERE Id = ?", id)
+		return err == nil, err
+	}
+	if found && e.Table == "Cards" {
+		var changed bool
+		var err error
+		if e, changed, err = mergeLocalCard(tx, id, e); !changed {
+			return false, err
+		}
+	} else if found && (e.Table == "Reviews" || !e.Modified.After(modified.Time)) {
+		return false, nil
+	}
+
+	// idOf resolves a reference to a parent row.
+	idOf := func(table string, guid string) (int, bool, error) {
+		var id int
+		err := tx.QueryRow("SELECT Id FROM "+table+" WHERE Guid = ?", guid).Scan(&id)
+		if errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Skipped %s %s, its parent in %s is missing", e.Table, e.Guid, table)
+			return 0, false, nil
+		}
+		return id, err == nil, err
+	}
+	// upsert updates the row when it exists and inserts it otherwise.
+	upsert := func(columns []string, values ...any) error {
+		values = append(values, e.Modified)
+		if found {
+			_, err := tx.Exec("UPDATE "+e.Table+" SET "+strings.Join(columns, " = ?, ")+" = ?, Modified = ? WHERE Id = ?", append(values, id)...)
+			return err
+		}
+		placeholders := strings.Repeat("?, ", len(columns)+1) + "?"
+		res, err := tx.Exec("INSERT INTO "+e.Table+"("+strings.Join(columns, ", ")+", Modified, Guid) VALUES ("+placeholders+")", append(values, e.Guid)...)
+		if err != nil {
+			return err
+		}
+		newId, err := res.LastInsertId()
+		id = int(newId)
+		return err
+	}
+
+	switch e.Table {
+	case "Decks":
+		deck := syncDeck{}
+		if err := json.Unmarshal(e.Data, &deck); err != nil {
+			return false, err
+		}
+		return true, upsert([]string{"Name"}, deck.Name)
+	case "NoteTypes":
+		nt := syncNoteType{}
+		if err := json.Unmarshal(e.Data, &nt); err != nil {
+			return false, err
+		}
+		fields, err := json.Marshal(nt.Fields)
+		if err != nil {
+			return false, err
+		}
+		name, err := freeNoteTypeName(tx, nt.Name, e.Guid)
+		if err != nil {
+			return false, err
+		}
+		if err := upsert([]string{"Name", "Fields"}, name, string(fields)); err != nil {
+			return false, err
+		}
+		if _, err := tx.Exec("DELETE FROM CardTemplates WHERE NoteTypeId = ?", id); err != nil {
+			return false, err
+		}
+		for ord, t := range nt.Templates {
+			stmt := "INSERT INTO CardTemplates(NoteTypeId, Ord, Name, Front, Back) VALUES (?, ?, ?, ?, ?)"
+			if _, err := tx.Exec(stmt, id, ord, t.Name, t.Front, t.Back); err != nil {
+				return false, err
+			}
+		}
+		return true, nil
+	case "Notes":
+		note := syncNote{}
+		if err := json.Unmarshal(e.Data, &note); err != nil {
+			return false, err
+		}
+		deckId, ok, err := idOf("Decks", note.Deck)
+		if !ok {
+			return false, err
+		}
+		noteTypeId, ok, err := idOf("NoteTypes", note.NoteType)
+		if !ok {
+			return false, err
+		}
+		fields, err := json.Marshal(note.Fields)
+		if err != nil {
+			return false, err
+		}
+		return true, upsert([]string{"DeckId", "NoteTypeId", "Fields", "Tags"}, deckId, noteTypeId, string(fields), note.Tags)
+	case "Cards":
+		card := syncCard{}
+		if err := json.Unmarshal(e.Data, &card); err != nil {
+			return false, err
+		}
+		deckId, ok, err := idOf("Decks", card.Deck)
+		if !ok {
+			return false, err
+		}
+		noteId, ok, err := idOf("Notes", card.Note)
+		if !ok {
+			return false, err
+		}
+		var buriedUntil any
+		if card.BuriedUntil != nil {
+			buriedUntil = card.BuriedUntil.UTC()
+		}
+		columns := []string{"DeckId", "NoteId", "Ord", "Front", "Back", "Interval", "EaseFactor", "Repetition", "ReviewDate", "BuriedUntil", "Suspended", "Flag", "Lapses"}
+		return true, upsert(columns, deckId, noteId, card.Ord, card.Front, card.Back, card.Interval, card.EaseFactor, card.Repetition, card.ReviewDate.UTC(), buriedUntil, card.Suspended, card.Flag, card.Lapses)
+	default:
+		review := syncReview{}
+		if err := json.Unmarshal(e.Data, &review); err != nil {
+			return false, err
+		}
+		cardId, ok, err := idOf("Cards", review.Card)
+		if !ok {
+			return false, err
+		}
+		stmt := "INSERT INTO Reviews(CardId, ReviewedAt, Grade, Interval, EaseFactor, Duration, Guid) VALUES (?, ?, ?, ?, ?, ?, ?)"
+		_, err = tx.Exec(stmt, cardId, review.ReviewedAt.UTC(), review.Grade, review.Interval, review.EaseFactor, review.Duration, e.Guid)
+		return err == nil, err
+	}
+}
+
+// freeNoteTypeName returns name, or a variant of it when another note type
+// created on a different device already uses it.
+func freeNoteTypeName(tx *sql.Tx, name string, guid string) (string, error) {
+	candidate := name
+	for i := 1; ; i++ {
+		var count int
+		if err := tx.QueryRow("SELECT COUNT(*) FROM NoteTypes WHERE Name = ? AND Guid IS NOT ?", candidate, guid).Scan(&count); err != nil {
+			return "", err
+		}
+		if count == 0 {
+			return candidate, nil
+		}
+		candidate = fmt.Sprintf("%s (synced %d)", name, i)
+	}
+}
+
+// migrateSync gives every synced row a Guid and a Modified time and
+// installs the triggers that keep them and ChangeLog up to date. Rows
+// written by older versions are logged once so the first sync sends them.
+func (db *DB) migrateSync() error {
+	for _, table := range syncTables {
+		if err := db.addColumnIfMissing(table, "Guid", "TEXT"); err != nil {
+			return err
+		}
+		if table != "Reviews" {
+			if err := db.addColumnIfMissing(table, "Modified", "DATETIME"); err != nil {
+				return err
+			}
+		}
+		if _, err := db.db.Exec(fmt.Sprintf("CREATE UNIQUE INDEX IF NOT EXISTS [%sGuid] ON [%s](Guid);", table, table)); err != nil {
+			return err
+		}
+	}
+	create := "CREATE TABLE IF NOT EXISTS [ChangeLog] ( Id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT, TableName TEXT NOT NULL, Guid TEXT NOT NULL, Deleted INTEGER NOT NULL DEFAULT 0, ChangedAt DATETIME NOT NULL); CREATE TABLE IF NOT EXISTS [SyncState] ( Key TEXT NOT NULL PRIMARY KEY, Value INTEGER NOT NULL);"
+	if _, err := db.db.Exec(create); err != nil {
+		return err
+	}
+
+	var version int
+	if err := db.db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+	if version < schemaVersionSync {
+		tx, err := db.db.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		// Built-in note types exist on every device, so they share a Guid.
+		for _, builtin := range builtinNoteTypes {
+			if _, err := tx.Exec("UPDATE NoteTypes SET Guid = ? WHERE Name = ?", "builtin:"+builtin.Name, builtin.Name); err != nil {
+				return err
+			}
+		}
+		for _, table := range syncTables {
+			stmt := "UPDATE " + table + " SET Guid = lower(hex(randomblob(16))) WHERE Guid IS NULL;"
+			if table != "Reviews" {
+				stmt += "UPDATE " + table + " SET Modified = " + syncNow + " WHERE Modified IS NULL;"
+			}
+			stmt += "INSERT INTO ChangeLog(TableName, Guid, ChangedAt) SELECT '" + table + "', Guid, " + syncNow + " FROM " + table + " ORDER BY Id;"
+			if _, err := tx.Exec(stmt); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", schemaVersionSync)); err != nil {
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+
+	// Rows written with a Modified time come from a sync and aren't logged;
+	// local writes never set it.
+	triggers := ""
+	for _, table := range syncTables[:len(syncTables)-1] {
+		triggers += fmt.Sprintf(`
+CREATE TRIGGER IF NOT EXISTS [%[1]sSyncInsert] AFTER INSERT ON [%[1]s] WHEN NEW.Modified IS NULL BEGIN
+	UPDATE [%[1]s] SET Guid = COALESCE(NEW.Guid, lower(hex(randomblob(16)))), Modified = %[2]s WHERE Id = NEW.Id;
+	INSERT INTO ChangeLog(TableName, Guid, ChangedAt) SELECT '%[1]s', Guid, %[2]s FROM [%[1]s] WHERE Id = NEW.Id;
+END;
+CREATE TRIGGER IF NOT EXISTS [%[1]sSyncUpdate] AFTER UPDATE ON [%[1]s] WHEN NEW.Modified IS OLD.Modified BEGIN
+	UPDATE [%[1]s] SET Modified = %[2]s WHERE Id = NEW.Id;
+	INSERT INTO ChangeLog(TableName, Guid, ChangedAt) VALUES ('%[1]s', NEW.Guid, %[2]s);
+END;
+CREATE TRIGGER IF NOT EXISTS [%[1]sSyncDelete] AFTER DELETE ON [%[1]s] WHEN OLD.Guid IS NOT NULL BEGIN
+	INSERT INTO ChangeLog(TableName, Guid, Deleted, ChangedAt) VALUES ('%[1]s', OLD.Guid, 1, %[2]s);
+END;`, table, syncNow)
+	}
+	triggers += fmt.Sprintf(`
+CREATE TRIGGER IF NOT EXISTS [ReviewsSyncInsert] AFTER INSERT ON [Reviews] WHEN NEW.Guid IS NULL BEGIN
+	UPDATE [Reviews] SET Guid = lower(hex(randomblob(16))) WHERE Id = NEW.Id;
+	INSERT INTO ChangeLog(TableName, Guid, ChangedAt) SELECT 'Reviews', Guid, %[1]s FROM [Reviews] WHERE Id = NEW.Id;
+END;`, syncNow)
+	_, err := db.db.Exec(triggers)
+	return err
+}
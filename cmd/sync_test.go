@@ -0,0 +1,187 @@
+package cmd
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestSyncServer serves sync for one user on localhost and returns a
+// client for it.
+func newTestSyncServer(t *testing.T) syncClient {
+	t.Helper()
+	server := &syncServer{dir: t.TempDir(), stores: map[string]*sql.DB{}}
+	if err := server.saveUsers(map[string]string{"alice": hashToken("secret")}); err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/sync/push", server.authenticated(server.push))
+	mux.HandleFunc("/sync/pull", server.authenticated(server.pull))
+	ts := httptest.NewServer(mux)
+	t.Cleanup(func() {
+		ts.Close()
+		for _, store := range server.stores {
+			store.Close()
+		}
+	})
+	return syncClient{http: ts.Client(), url: ts.URL, user: "alice", token: "secret"}
+}
+
+func mustSync(t *testing.T, db *DB, client syncClient) {
+	t.Helper()
+	if _, _, err := db.sync(client); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func mustExec(t *testing.T, db *DB, stmt string, args ...any) {
+	t.Helper()
+	if _, err := db.db.Exec(stmt, args...); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func countRows(t *testing.T, db *DB, table string) int {
+	t.Helper()
+	var count int
+	if err := db.db.QueryRow("SELECT COUNT(*) FROM " + table).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+// onlyCard returns the card of a collection expected to hold one.
+func onlyCard(t *testing.T, db *DB) BaseCard {
+	t.Helper()
+	cards := db.getAllCards()
+	if len(cards) != 1 {
+		t.Fatalf("%d cards, want 1", len(cards))
+	}
+	return cards[0]
+}
+
+func TestSyncRoundTrip(t *testing.T) {
+	client := newTestSyncServer(t)
+	now := localTime(2024, 5, 10, 9, 0)
+	dbA, clockA := newTestDb(t, now)
+	dbB, clockB := newTestDb(t, now)
+
+	addTestCard(t, dbA, addTestDeck(t, dbA, "Spanish"), "hola", "hello")
+	mustSync(t, dbA, client)
+	mustSync(t, dbB, client)
+	if card := onlyCard(t, dbB); card.Front != "hola" {
+		t.Fatalf("B received %q, want hola", card.Front)
+	}
+
+	t.Run("conflicting edit", func(t *testing.T) {
+		mustExec(t, dbA, "UPDATE Cards SET Flag = 1")
+		// Modified times have millisecond precision.
+		time.Sleep(10 * time.Millisecond)
+		mustExec(t, dbB, "UPDATE Cards SET Flag = 2")
+		mustSync(t, dbB, client)
+		mustSync(t, dbA, client)
+		mustSync(t, dbB, client)
+		for name, db := range map[string]*DB{"A": dbA, "B": dbB} {
+			if flag := onlyCard(t, db).Flag; flag != 2 {
+				t.Errorf("%s has flag %d, want the later edit 2", name, flag)
+			}
+		}
+	})
+
+	t.Run("concurrent reviews", func(t *testing.T) {
+		// B reviews later in the day but A's review is written last, so
+		// only the review times tell whose scheduling to keep.
+		clockB.t = now.Add(time.Hour)
+		cardB := onlyCard(t, dbB)
+		cardB.updateCard(5, time.Second, dbB)
+		time.Sleep(10 * time.Millisecond)
+		cardA := onlyCard(t, dbA)
+		cardA.updateCard(4, time.Second, dbA)
+		want := onlyCard(t, dbB)
+
+		mustSync(t, dbA, client)
+		mustSync(t, dbB, client)
+		mustSync(t, dbA, client)
+		for name, db := range map[string]*DB{"A": dbA, "B": dbB} {
+			if reviews := countRows(t, db, "Reviews"); reviews != 2 {
+				t.Errorf("%s has %d reviews, want both devices' 2", name, reviews)
+			}
+			if got := onlyCard(t, db); !sameSchedule(got, want) {
+				t.Errorf("%s has the schedule %v, want the one of the last review %v", name, got, want)
+			}
+		}
+	})
+
+	t.Run("edit after a review", func(t *testing.T) {
+		clockA.t = now.Add(2 * time.Hour)
+		cardA := onlyCard(t, dbA)
+		cardA.updateCard(5, time.Second, dbA)
+		want := onlyCard(t, dbA)
+		time.Sleep(10 * time.Millisecond)
+		mustExec(t, dbB, "UPDATE Cards SET Flag = 3")
+
+		mustSync(t, dbA, client)
+		mustSync(t, dbB, client)
+		mustSync(t, dbA, client)
+		for name, db := range map[string]*DB{"A": dbA, "B": dbB} {
+			got := onlyCard(t, db)
+			if !sameSchedule(got, want) || got.Flag != 3 {
+				t.Errorf("%s has %v, want the schedule of A's review %v and B's flag 3", name, got, want)
+			}
+			if reviews := countRows(t, db, "Reviews"); reviews != 3 {
+				t.Errorf("%s has %d reviews, want 3", name, reviews)
+			}
+		}
+	})
+
+	t.Run("restore replace", func(t *testing.T) {
+		dump, err := dbA.dumpCollection()
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(t.TempDir(), "backup.json")
+		if err := writeDump(path, dump); err != nil {
+			t.Fatal(err)
+		}
+		// B reviews and adds a card after the backup was taken and A
+		// receives them, so the restore drops rows the other devices have.
+		cardB := onlyCard(t, dbB)
+		cardB.updateCard(5, time.Second, dbB)
+		deck, err := dbB.getDeckByName("Spanish")
+		if err != nil {
+			t.Fatal(err)
+		}
+		addTestCard(t, dbB, deck.Id, "adios", "bye")
+		mustSync(t, dbB, client)
+		mustSync(t, dbA, client)
+
+		read, err := readDump(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := dbA.restoreDump(read, true); err != nil {
+			t.Fatal(err)
+		}
+		mustSync(t, dbA, client)
+		mustSync(t, dbB, client)
+		for name, db := range map[string]*DB{"A": dbA, "B": dbB} {
+			cards := db.getAllCards()
+			if len(cards) != 2 || cards[0].Front != "hola" || cards[0].Flag != 3 || cards[1].Front != "adios" {
+				t.Errorf("%s has cards %v after the restore, want hola flagged 3 and adios", name, cards)
+			}
+			if notes := countRows(t, db, "Notes"); notes != 2 {
+				t.Errorf("%s has %d notes, want 2", name, notes)
+			}
+			if reviews := countRows(t, db, "Reviews"); reviews != 4 {
+				t.Errorf("%s has %d reviews, want 4", name, reviews)
+			}
+		}
+	})
+}
+
+func sameSchedule(a BaseCard, b BaseCard) bool {
+	return a.Interval == b.Interval && a.EaseFactor == b.EaseFactor && a.Repetition == b.Repetition && a.ReviewDate.Equal(b.ReviewDate) && a.Lapses == b.Lapses
+}
@@ -0,0 +1,311 @@
+package cmd
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/exp/slices"
+)
+
+// maxPushBytes limits the body of a push; a collection of tens of thousands
+// of cards with their reviews fits well within it.
+const maxPushBytes = 256 << 20
+
+var syncUserName = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// syncServerCmd represents the sync-server command
+var syncServerCmd = &cobra.Command{
+	Use:   "sync-server",
+	Short: "Serve collections to playita sync",
+	Long: `
+Start an HTTP server keeping a copy of the collection of each of its users,
+which playita sync sends changes to and receives changes from. Users are
+added with sync-server add-user and each has a database in --dir.
+
+The server has no TLS of its own; outside of a trusted network put it
+behind a proxy that has.
+	`,
+	Example: `  playita sync-server add-user alice
+  playita sync-server --addr 127.0.0.1:8765`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		addr, _ := cmd.Flags().GetString("addr")
+		dir, _ := cmd.Flags().GetString("dir")
+
+		server := &syncServer{dir: dir, stores: map[string]*sql.DB{}}
+		users, err := server.users()
+		if err != nil {
+			log.Fatal("Failed to read users ", err)
+		}
+		if len(users) == 0 {
+			log.Println("No users yet, add one with playita sync-server add-user")
+		}
+
+		mux := http.NewServeMux()
+		mux.HandleFunc("/sync/push", server.authenticated(server.push))
+		mux.HandleFunc("/sync/pull", server.authenticated(server.pull))
+		log.Printf("Serving sync on http://%s\n", addr)
+		s := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+		log.Fatal(s.ListenAndServe())
+	},
+}
+
+var syncServerAddUserCmd = &cobra.Command{
+	Use:   "add-user <name>",
+	Short: "Add a user to the sync server and print its token",
+	Long: `
+Add a user, or give an existing one a new token, and print the token to
+set as sync-token on each of the user's devices. Only a hash of it is kept.
+	`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		dir, _ := cmd.Flags().GetString("dir")
+		if !syncUserName.MatchString(args[0]) {
+			log.Fatalf("Invalid user name %q, use letters, digits, '.', '-' and '_'\n", args[0])
+		}
+
+		server := &syncServer{dir: dir}
+		users, err := server.users()
+		if err != nil {
+			log.Fatal("Failed to read users ", err)
+		}
+		secret := make([]byte, 24)
+		if _, err := rand.Read(secret); err != nil {
+			log.Fatal(err)
+		}
+		token := hex.EncodeToString(secret)
+		users[args[0]] = hashToken(token)
+		if err := server.saveUsers(users); err != nil {
+			log.Fatal("Failed to save users ", err)
+		}
+		fmt.Println(token)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(syncServerCmd)
+	syncServerCmd.AddCommand(syncServerAddUserCmd)
+
+	syncServerCmd.PersistentFlags().String("dir", dbFile+"-sync", "directory of the users and their collections")
+	syncServerCmd.Flags().String("addr", "127.0.0.1:8765", "address to listen on")
+}
+
+type syncServer struct {
+	dir    string
+	mu     sync.Mutex
+	stores map[string]*sql.DB
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *syncServer) usersFile() string {
+	return filepath.Join(s.dir, "users.json")
+}
+
+// users maps each user to the hash of their token.
+func (s *syncServer) users() (map[string]string, error) {
+	users := map[string]string{}
+	data, err := os.ReadFile(s.usersFile())
+	if os.IsNotExist(err) {
+		return users, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return users, json.Unmarshal(data, &users)
+}
+
+func (s *syncServer) saveUsers(users map[string]string) error {
+	if err := os.MkdirAll(s.dir, 0o700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(users, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.usersFile() + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.usersFile())
+}
+
+// authenticated checks the user and token given with basic auth before
+// passing the request on with the user's store. Users are read on every
+// request so add-user takes effect without a restart.
+func (s *syncServer) authenticated(handler func(http.ResponseWriter, *http.Request, *sql.DB)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, token, ok := r.BasicAuth()
+		users, err := s.users()
+		if err != nil {
+			log.Println("Failed to read users ", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		hash, known := users[user]
+		if !ok || !known || subtle.ConstantTimeCompare([]byte(hash), []byte(hashToken(token))) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="playita"`)
+			http.Error(w, "unknown user or token", http.StatusUnauthorized)
+			return
+		}
+		store, err := s.store(user)
+		if err != nil {
+			log.Printf("Failed to open the store of %s: %v\n", user, err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		handler(w, r, store)
+	}
+}
+
+// store opens the database of a user, which holds the latest version of
+// every row their devices sent. Seq orders the rows by when the server
+// received them, so a device pulls what came after the last Seq it saw.
+func (s *syncServer) store(user string) (*sql.DB, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if store, ok := s.stores[user]; ok {
+		return store, nil
+	}
+	if err := os.MkdirAll(s.dir, 0o700); err != nil {
+		return nil, err
+	}
+	store, err := sql.Open("sqlite3", filepath.Join(s.dir, user+".db"))
+	if err != nil {
+		return nil, err
+	}
+	store.SetMaxOpenConns(1)
+	create := "CREATE TABLE IF NOT EXISTS [Entities] ( Seq INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT, TableName TEXT NOT NULL, Guid TEXT NOT NULL, Modified DATETIME NOT NULL, Deleted INTEGER NOT NULL, Data TEXT NOT NULL, UNIQUE(TableName, Guid));"
+	if _, err := store.Exec(create); err != nil {
+		store.Close()
+		return nil, err
+	}
+	s.stores[user] = store
+	return store, nil
+}
+
+// push keeps each entity unless the store has a version modified at the
+// same time or later. Reviews never change, so a known one is skipped, and
+// cards are merged with the stored version so neither side's last review
+// is undone.
+func (s *syncServer) push(w http.ResponseWriter, r *http.Request, store *sql.DB) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body := syncPush{}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxPushBytes)).Decode(&body); err != nil {
+		http.Error(w, "invalid push: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	for _, e := range body.Entities {
+		if !slices.Contains(syncTables, e.Table) || e.Guid == "" {
+			http.Error(w, fmt.Sprintf("invalid entity %q %q", e.Table, e.Guid), http.StatusBadRequest)
+			return
+		}
+	}
+
+	err := func() error {
+		tx, err := store.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+		for _, e := range body.Entities {
+			stored := syncEntity{Table: e.Table, Guid: e.Guid}
+			var data string
+			err := tx.QueryRow("SELECT Modified, Deleted, Data FROM Entities WHERE TableName = ? AND Guid = ?", e.Table, e.Guid).Scan(&stored.Modified, &stored.Deleted, &data)
+			if err == nil && e.Table == "Cards" && !e.Deleted && !stored.Deleted {
+				stored.Data = json.RawMessage(data)
+				if e, err = mergeSyncCards(stored, e); err != nil {
+					return err
+				}
+				if e.Modified.Equal(stored.Modified) && string(e.Data) == data {
+					continue
+				}
+			} else if err == nil && (e.Table == "Reviews" || !e.Modified.After(stored.Modified)) {
+				continue
+			} else if err != nil && !errors.Is(err, sql.ErrNoRows) {
+				return err
+			}
+			// Deleting first gives the new version a new Seq.
+			if _, err := tx.Exec("DELETE FROM Entities WHERE TableName = ? AND Guid = ?", e.Table, e.Guid); err != nil {
+				return err
+			}
+			stmt := "INSERT INTO Entities(TableName, Guid, Modified, Deleted, Data) VALUES (?, ?, ?, ?, ?)"
+			if _, err := tx.Exec(stmt, e.Table, e.Guid, e.Modified.UTC(), e.Deleted, string(e.Data)); err != nil {
+				return err
+			}
+		}
+		return tx.Commit()
+	}()
+	if err != nil {
+		log.Println("Failed to store push ", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	ack := syncPull{Entities: []syncEntity{}}
+	if err := store.QueryRow("SELECT COALESCE(MAX(Seq), 0) FROM Entities").Scan(&ack.Seq); err != nil {
+		log.Println("Failed to read sequence ", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	respond(w, ack)
+}
+
+// pull returns the entities received after the Seq given as since.
+func (s *syncServer) pull(w http.ResponseWriter, r *http.Request, store *sql.DB) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	since, err := strconv.ParseInt(r.URL.Query().Get("since"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid since", http.StatusBadRequest)
+		return
+	}
+
+	// Seq is taken from the rows read, as more may be pushed meanwhile.
+	pull := syncPull{Entities: []syncEntity{}, Seq: since}
+	err = scanEach(store, "SELECT Seq, TableName, Guid, Modified, Deleted, Data FROM Entities WHERE Seq > "+strconv.FormatInt(since, 10)+" ORDER BY Seq", func(rows *sql.Rows) error {
+		e := syncEntity{}
+		var data string
+		if err := rows.Scan(&pull.Seq, &e.Table, &e.Guid, &e.Modified, &e.Deleted, &data); err != nil {
+			return err
+		}
+		e.Modified = e.Modified.UTC()
+		if data != "" && data != "null" {
+			e.Data = json.RawMessage(data)
+		}
+		pull.Entities = append(pull.Entities, e)
+		return nil
+	})
+	if err != nil {
+		log.Println("Failed to read pull ", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	respond(w, pull)
+}
+
+func respond(w http.ResponseWriter, pull syncPull) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pull)
+}